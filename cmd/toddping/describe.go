@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+// description is what "toddping describe" prints, so that ToDD can validate testruns before
+// dispatching them. The args and metrics come from the same definitions Run works from.
+type description struct {
+	Name      string                 `json:"name"`
+	Version   string                 `json:"version"`
+	Args      map[string]interface{} `json:"args"` // a JSON Schema for the args map
+	Metrics   []ping.MetricDef       `json:"metrics"`
+	Platforms []string               `json:"platforms"`
+}
+
+func describe() error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(description{
+		Name:      testletName,
+		Version:   version,
+		Args:      ping.ArgsSchema(),
+		Metrics:   ping.Metrics,
+		Platforms: platforms,
+	})
+}
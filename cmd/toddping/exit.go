@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+// Exit statuses of a run. The loss statuses blame the target, and are only reported once a run
+// completed; the failures, which follow sysexits.h, blame toddping or how it was run. 1 and 2
+// are left out, as log.Fatal and Go's runtime exit with them: either means toddping crashed.
+const (
+	exitSuccess     = 0   // every probe was answered (or, in sweep mode, every host)
+	exitPartialLoss = 3   // some probes went unanswered
+	exitTotalLoss   = 4   // no probes were answered
+	exitInvalidArgs = 64  // EX_USAGE: the flags, args or target can't be run
+	exitInternal    = 70  // EX_SOFTWARE: the run failed for any other reason
+	exitPermission  = 77  // EX_NOPERM: ICMP sockets can't be opened, usually for lack of capabilities
+	exitInterrupted = 130 // stopped by SIGINT or SIGTERM, as shells report for SIGINT
+)
+
+// exitCodes maps the error codes of failed runs to exit statuses
+var exitCodes = map[string]int{
+	ping.CodeInvalidArgs: exitInvalidArgs,
+	ping.CodePermission:  exitPermission,
+	ping.CodeInternal:    exitInternal,
+}
+
+// errorObject is printed on stdout when a run fails, so the ToDD agent has more to go on than
+// the exit status
+type errorObject struct {
+	Error   string `json:"error"` // one of the ping.Code* constants
+	Message string `json:"message"`
+	Target  string `json:"target,omitempty"`
+}
+
+// exitCode returns the exit status for a failure
+func exitCode(err error) int {
+	return exitCodes[ping.ErrorCode(err)]
+}
+
+// reportError reports a failure, against target if it's down to one, and returns the exit status
+// for it. The error object is printed for the JSON formats, whose output is parsed; the others
+// get a plain message on stderr instead, so as not to break up their own output.
+func reportError(format, target string, err error) int {
+	code := ping.ErrorCode(err)
+	log.Error(err)
+
+	if format == formatJSON || format == formatJSONL {
+		json.NewEncoder(os.Stdout).Encode(errorObject{Error: code, Message: err.Error(), Target: target})
+	} else if target != "" {
+		fmt.Fprintf(os.Stderr, "toddping: %s: %v\n", target, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "toddping: %v\n", err)
+	}
+	return exitCode(err)
+}
+
+// fail reports a failure that stops toddping altogether, and exits
+func fail(format, target string, err error) {
+	os.Exit(reportError(format, target, err))
+}
+
+// combinedExitCode returns the exit status for runs against several targets: that of the first to
+// fail, if any did, and otherwise total loss only if every target was lost
+func combinedExitCode(codes []int) int {
+	for _, code := range codes {
+		if code > exitTotalLoss {
+			return code
+		}
+	}
+
+	var answered, lost int
+	for _, code := range codes {
+		switch code {
+		case exitSuccess:
+			answered++
+		case exitTotalLoss:
+			lost++
+		}
+	}
+
+	switch {
+	case answered == len(codes):
+		return exitSuccess
+	case lost == len(codes):
+		return exitTotalLoss
+	}
+	return exitPartialLoss
+}
+
+// lossExitCode returns the exit status for a completed run, from its packet loss (or the share
+// of dead hosts in sweep mode)
+func lossExitCode(r *ping.Results) int {
+	loss, ok := r.Metrics["packet_loss"]
+	if !ok {
+		swept, dead := r.Metrics["hosts_swept"], r.Metrics["hosts_dead"]
+		if swept == 0 {
+			return exitSuccess
+		}
+		loss = dead / swept
+	}
+
+	switch {
+	case loss >= 1:
+		return exitTotalLoss
+	case loss > 0:
+		return exitPartialLoss
+	}
+	return exitSuccess
+}
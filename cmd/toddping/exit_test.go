@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{&ping.RunError{Code: ping.CodeInvalidArgs, Err: errors.New("bad count")}, exitInvalidArgs},
+		{&ping.RunError{Code: ping.CodePermission, Err: errors.New("no raw socket")}, exitPermission},
+		{&ping.RunError{Code: ping.CodeInternal, Err: errors.New("oops")}, exitInternal},
+		{fmt.Errorf("listen: %w", os.ErrPermission), exitPermission},
+		{errors.New("unclassified"), exitInternal},
+	} {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestLossExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		metrics map[string]float32
+		want    int
+	}{
+		{"no loss", map[string]float32{"packet_loss": 0}, exitSuccess},
+		{"some loss", map[string]float32{"packet_loss": 0.01}, exitPartialLoss},
+		{"total loss", map[string]float32{"packet_loss": 1}, exitTotalLoss},
+		{"every host alive", map[string]float32{"hosts_swept": 4, "hosts_dead": 0}, exitSuccess},
+		{"some hosts dead", map[string]float32{"hosts_swept": 4, "hosts_dead": 1}, exitPartialLoss},
+		{"every host dead", map[string]float32{"hosts_swept": 4, "hosts_dead": 4}, exitTotalLoss},
+		{"nothing swept", map[string]float32{"hosts_swept": 0, "hosts_dead": 0}, exitSuccess},
+		{"no metrics", map[string]float32{}, exitSuccess},
+	} {
+		if got := lossExitCode(&ping.Results{Metrics: tc.metrics}); got != tc.want {
+			t.Errorf("%s: lossExitCode = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCombinedExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		codes []int
+		want  int
+	}{
+		{"one answered", []int{exitSuccess}, exitSuccess},
+		{"one partly lost", []int{exitPartialLoss}, exitPartialLoss},
+		{"one lost", []int{exitTotalLoss}, exitTotalLoss},
+		{"all answered", []int{exitSuccess, exitSuccess}, exitSuccess},
+		{"all lost", []int{exitTotalLoss, exitTotalLoss}, exitTotalLoss},
+
+		// Losing some targets entirely is only partial loss overall
+		{"one of two lost", []int{exitSuccess, exitTotalLoss}, exitPartialLoss},
+		{"partly and totally lost", []int{exitPartialLoss, exitTotalLoss}, exitPartialLoss},
+
+		// Failures take precedence over loss, with the first of them reported
+		{"failure after loss", []int{exitTotalLoss, exitInvalidArgs}, exitInvalidArgs},
+		{"failure before success", []int{exitPermission, exitSuccess}, exitPermission},
+		{"different failures", []int{exitSuccess, exitInternal, exitInvalidArgs, exitPermission}, exitInternal},
+		{"every target failed", []int{exitInvalidArgs, exitInvalidArgs}, exitInvalidArgs},
+	} {
+		if got := combinedExitCode(tc.codes); got != tc.want {
+			t.Errorf("%s: combinedExitCode(%v) = %d, want %d", tc.name, tc.codes, got, tc.want)
+		}
+	}
+}
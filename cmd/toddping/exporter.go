@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	cli "github.com/codegangsta/cli"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+// exporterFlags configure the "exporter" command
+var exporterFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "l, listen",
+		Usage: "address to serve HTTP on",
+		Value: ":9115",
+	},
+	cli.BoolFlag{
+		Name:  "openmetrics",
+		Usage: "render probe results in the OpenMetrics text format rather than Prometheus'",
+	},
+}
+
+// scrapeTimeoutOffset is taken off the scrape timeout Prometheus sends, leaving time to write the
+// results before it gives up on the scrape
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// exporter serves ping runs over HTTP, in the manner of Prometheus' blackbox exporter
+type exporter struct {
+	openMetrics bool
+
+	inFlight, probes, errors int64
+}
+
+// runExporter serves /probe, which runs the testlet against a target on demand and returns the
+// results as Prometheus metrics, and /metrics, with the exporter's own health
+func runExporter(c *cli.Context) error {
+
+	e := &exporter{openMetrics: c.Bool("openmetrics")}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/probe", e.probe)
+	mux.HandleFunc("/metrics", e.metrics)
+
+	log.Infof("Serving ping probes on %s", c.String("listen"))
+	return http.ListenAndServe(c.String("listen"), mux)
+}
+
+// probe handles /probe?target=...&count=..., also accepting timeout (seconds), interval (ms),
+// protocol and port
+func (e *exporter) probe(w http.ResponseWriter, r *http.Request) {
+
+	atomic.AddInt64(&e.inFlight, 1)
+	defer atomic.AddInt64(&e.inFlight, -1)
+	atomic.AddInt64(&e.probes, 1)
+
+	q := r.URL.Query()
+	target := q.Get("target")
+	if target == "" {
+		e.fail(w, http.StatusBadRequest, "target parameter is missing")
+		return
+	}
+
+	args := map[string]interface{}{
+		"count":       3,
+		"icmpTimeout": 3,
+		"records":     true,
+	}
+	for _, param := range []struct{ name, arg string }{
+		{"count", "count"},
+		{"timeout", "icmpTimeout"},
+		{"interval", "interval_ms"},
+		{"port", "port"},
+	} {
+		v := q.Get(param.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			e.fail(w, http.StatusBadRequest, fmt.Sprintf("%s must be a non-negative integer", param.name))
+			return
+		}
+		args[param.arg] = n
+	}
+	if protocol := q.Get("protocol"); protocol != "" {
+		args["protocol"] = protocol
+	}
+	if err := ping.ValidateArgs(args); err != nil {
+		e.fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := scrapeContext(r)
+	defer cancel()
+
+	// Like the blackbox exporter, a probe that fails (including one whose target can't be
+	// resolved) is still a successful scrape, with ping_probe_success reporting the failure
+	results, err := ping.PingTestlet{}.RunContext(ctx, target, args, 30)
+	if err != nil {
+		atomic.AddInt64(&e.errors, 1)
+		log.Warnf("Probe of %s failed: %v", target, err)
+	}
+
+	w.Header().Set("Content-Type", e.contentType())
+	if err := ping.WritePrometheus(w, []ping.TargetResults{{Target: target, Results: results, Err: err}}, e.openMetrics); err != nil {
+		log.Errorf("Failed to write results for %s: %v", target, err)
+	}
+}
+
+// scrapeContext returns the context to run a probe in: the request's, cut short ahead of the
+// scrape timeout if Prometheus sent one, so that a slow run still returns the probes it got through
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return context.WithCancel(r.Context())
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		log.Warnf("Ignoring invalid scrape timeout '%s'", v)
+		return context.WithCancel(r.Context())
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// fail reports a probe request with bad parameters
+func (e *exporter) fail(w http.ResponseWriter, status int, message string) {
+	atomic.AddInt64(&e.errors, 1)
+	log.Warnf("Probe failed: %s", message)
+	http.Error(w, message, status)
+}
+
+// metrics handles /metrics, describing the exporter itself
+func (e *exporter) metrics(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	// Raw sockets can see everything; datagram sockets only work where unprivileged ping
+	// sockets are permitted, and 0 for both means neither is available
+	mode, err := ping.SocketMode()
+	if err != nil {
+		log.Warnf("Unable to open an ICMP socket: %v", err)
+	}
+	fmt.Fprintln(w, "# HELP toddping_exporter_socket_mode Kind of ICMP socket available to the exporter")
+	fmt.Fprintln(w, "# TYPE toddping_exporter_socket_mode gauge")
+	for _, m := range []string{"raw", "datagram"} {
+		v := 0
+		if m == mode {
+			v = 1
+		}
+		fmt.Fprintf(w, "toddping_exporter_socket_mode{mode=\"%s\"} %d\n", m, v)
+	}
+
+	fmt.Fprintln(w, "# HELP toddping_exporter_probes_in_flight Probes currently running")
+	fmt.Fprintln(w, "# TYPE toddping_exporter_probes_in_flight gauge")
+	fmt.Fprintf(w, "toddping_exporter_probes_in_flight %d\n", atomic.LoadInt64(&e.inFlight))
+
+	fmt.Fprintln(w, "# HELP toddping_exporter_probes_total Probes requested")
+	fmt.Fprintln(w, "# TYPE toddping_exporter_probes_total counter")
+	fmt.Fprintf(w, "toddping_exporter_probes_total %d\n", atomic.LoadInt64(&e.probes))
+
+	fmt.Fprintln(w, "# HELP toddping_exporter_probe_errors_total Probes that couldn't be carried out, due to bad parameters or errors running them")
+	fmt.Fprintln(w, "# TYPE toddping_exporter_probe_errors_total counter")
+	fmt.Fprintf(w, "toddping_exporter_probe_errors_total %d\n", atomic.LoadInt64(&e.errors))
+}
+
+// contentType is the Content-Type of probe results
+func (e *exporter) contentType() string {
+	if e.openMetrics {
+		return "application/openmetrics-text; version=1.0.0; charset=utf-8"
+	}
+	return "text/plain; version=0.0.4; charset=utf-8"
+}
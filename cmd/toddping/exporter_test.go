@@ -0,0 +1,218 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// get runs a request against one of the exporter's handlers, returning the response
+func get(h http.HandlerFunc, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// listenTCP accepts connections on a free loopback port until the test ends, returning the port
+func listenTCP(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestExporterProbe(t *testing.T) {
+	e := &exporter{}
+	port := listenTCP(t)
+
+	q := url.Values{
+		"target":   {"127.0.0.1"},
+		"protocol": {"tcp"},
+		"port":     {strconv.Itoa(port)},
+		"count":    {"2"},
+		"interval": {"0"},
+	}
+	w := get(e.probe, "/probe?"+q.Encode())
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE ping_packet_loss gauge\n",
+		"ping_packet_loss{target=\"127.0.0.1\"} 0\n",
+		"ping_probe_success{target=\"127.0.0.1\"} 1\n",
+		"ping_avg_latency_ms{target=\"127.0.0.1\"} ",
+		"# TYPE ping_rtt_seconds histogram\n",
+		"ping_rtt_seconds_count{target=\"127.0.0.1\"} 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "ping_interrupted") {
+		t.Errorf("uninterrupted run reported as interrupted:\n%s", body)
+	}
+}
+
+func TestExporterProbeOpenMetrics(t *testing.T) {
+	e := &exporter{openMetrics: true}
+	port := listenTCP(t)
+
+	w := get(e.probe, "/probe?target=127.0.0.1&protocol=tcp&count=1&port="+strconv.Itoa(port))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if body := w.Body.String(); !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("OpenMetrics output doesn't end with # EOF:\n%s", body)
+	}
+}
+
+func TestExporterProbeBadRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name, query string
+	}{
+		{"missing target", "count=1"},
+		{"non-numeric count", "target=127.0.0.1&count=lots"},
+		{"negative timeout", "target=127.0.0.1&timeout=-1"},
+		{"unknown protocol", "target=127.0.0.1&protocol=carrier-pigeon"},
+		{"zero count", "target=127.0.0.1&count=0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &exporter{}
+			w := get(e.probe, "/probe?"+tc.query)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+			}
+			if e.probes != 1 || e.errors != 1 {
+				t.Errorf("probes %d, errors %d, want 1, 1", e.probes, e.errors)
+			}
+		})
+	}
+}
+
+func TestExporterProbeFailure(t *testing.T) {
+
+	// A UDP socket that never echoes anything back
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	port := silent.LocalAddr().(*net.UDPAddr).Port
+
+	for _, tc := range []struct {
+		name, query string
+	}{
+		// Runs that fail, and ones that get no answer, are reported by ping_probe_success
+		{"invalid target", "target=not..an..address"},
+		{"link-local target without a zone", "target=fe80::1"},
+		{"no answer", "target=127.0.0.1&protocol=udp&count=1&timeout=1&port=" + strconv.Itoa(port)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &exporter{}
+			w := get(e.probe, "/probe?"+tc.query)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+			}
+			target := strings.TrimPrefix(strings.SplitN(tc.query, "&", 2)[0], "target=")
+			if want := "ping_probe_success{target=\"" + target + "\"} 0\n"; !strings.Contains(w.Body.String(), want) {
+				t.Errorf("missing %q in:\n%s", want, w.Body)
+			}
+		})
+	}
+}
+
+func TestExporterMetrics(t *testing.T) {
+	e := &exporter{}
+	get(e.probe, "/probe")
+	get(e.probe, "/probe?target=127.0.0.1&count=x")
+
+	w := get(e.metrics, "/metrics")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		"# TYPE toddping_exporter_probes_total counter\n",
+		"toddping_exporter_probes_total 2\n",
+		"toddping_exporter_probe_errors_total 2\n",
+		"toddping_exporter_probes_in_flight 0\n",
+		"toddping_exporter_socket_mode{mode=\"raw\"} ",
+		"toddping_exporter_socket_mode{mode=\"datagram\"} ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestScrapeContext(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   time.Duration // 0 for no deadline
+	}{
+		{"", 0},
+		{"10", 10*time.Second - scrapeTimeoutOffset},
+		{"2.5", 2 * time.Second},
+		{"0.25", 250 * time.Millisecond},
+		{"soon", 0},
+		{"-1", 0},
+	} {
+		t.Run(tc.header, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/probe", nil)
+			if tc.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tc.header)
+			}
+
+			start := time.Now()
+			ctx, cancel := scrapeContext(r)
+			end := time.Now()
+			defer cancel()
+
+			deadline, ok := ctx.Deadline()
+			if tc.want == 0 {
+				if ok {
+					t.Errorf("deadline in %v, want none", deadline.Sub(start))
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("no deadline, want one in %v", tc.want)
+			}
+			if deadline.Before(start.Add(tc.want)) || deadline.After(end.Add(tc.want)) {
+				t.Errorf("deadline in %v, want %v", deadline.Sub(start), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+// Output formats, chosen with --format
+const (
+	formatJSON        = "json"        // a single JSON object once the run is over
+	formatJSONL       = "jsonl"       // a JSON object per line for each probe event, then one with the results
+	formatPrometheus  = "prometheus"  // Prometheus text exposition format, e.g. for node_exporter's textfile collector
+	formatOpenMetrics = "openmetrics" // OpenMetrics text format
+	formatText        = "text"        // a line per probe and a summary, like the classic ping command
+)
+
+// output writes a run's results in one of the formats chosen with --format. Only the results
+// are written to stdout; logging goes to stderr, so that stdout can always be parsed.
+type output interface {
+	// event is called as each probe event happens
+	event(e ping.Event)
+
+	// results is called once the run against target is over
+	results(target string, r *ping.Results) error
+
+	// close is called once all runs are over
+	close() error
+}
+
+// newOutput returns the output for format, writing to w. protocol is the probe protocol in use,
+// and multi is set when there's more than one target.
+func newOutput(format, protocol string, multi bool, w io.Writer) (output, error) {
+	switch format {
+	case formatJSON:
+		return jsonOutput{w, multi}, nil
+	case formatJSONL:
+		return jsonlOutput{json.NewEncoder(w)}, nil
+	case formatPrometheus, formatOpenMetrics:
+		return &prometheusOutput{w: w, openMetrics: format == formatOpenMetrics}, nil
+	case formatText:
+		return newTextOutput(w, protocol), nil
+	}
+	return nil, fmt.Errorf("unsupported format '%s'", format)
+}
+
+// jsonOutput prints the results as a single JSON object, or a line per target when there are
+// several (each then naming its target)
+type jsonOutput struct {
+	w          io.Writer
+	withTarget bool
+}
+
+func (o jsonOutput) event(e ping.Event) {}
+
+func (o jsonOutput) close() error { return nil }
+
+func (o jsonOutput) results(target string, r *ping.Results) error {
+
+	// The metrics infrastructure requires that we collect metrics as a JSON string
+	// (which is a result of building non-native testlets in early versions of ToDD)
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if o.withTarget {
+		if b, err = withKey(b, "target", target); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(o.w, string(b))
+	return err
+}
+
+// withKey adds a key to a JSON object, keeping the existing values exactly as they were rendered
+func withKey(object []byte, key string, value interface{}) ([]byte, error) {
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(object, &m); err != nil {
+		return nil, err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	m[key] = v
+	return json.Marshal(m)
+}
+
+// jsonlOutput streams a JSON object per line for each event, and finishes with a "summary" object
+// holding the same keys as jsonOutput's
+type jsonlOutput struct {
+	enc *json.Encoder
+}
+
+func (o jsonlOutput) event(e ping.Event) {
+	o.enc.Encode(e)
+}
+
+func (o jsonlOutput) close() error { return nil }
+
+func (o jsonlOutput) results(target string, r *ping.Results) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if b, err = withKey(b, "event", "summary"); err != nil {
+		return err
+	}
+	if b, err = withKey(b, "target", target); err != nil {
+		return err
+	}
+	return o.enc.Encode(json.RawMessage(b))
+}
+
+// prometheusOutput renders the results of every run together once they're all over, as each
+// metric's samples have to be grouped
+type prometheusOutput struct {
+	w           io.Writer
+	openMetrics bool
+	runs        []ping.TargetResults
+}
+
+func (o *prometheusOutput) event(e ping.Event) {}
+
+func (o *prometheusOutput) results(target string, r *ping.Results) error {
+	o.runs = append(o.runs, ping.TargetResults{Target: target, Results: r})
+	return nil
+}
+
+func (o *prometheusOutput) close() error {
+	return ping.WritePrometheus(o.w, o.runs, o.openMetrics)
+}
@@ -9,11 +9,14 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	cli "github.com/codegangsta/cli"
@@ -23,25 +26,32 @@ import (
 
 var (
 	testletName = "ping"
+	version     = "v0.1.0"
+
+	// platforms are the operating systems the testlet supports
+	platforms = []string{"darwin", "linux"}
 )
 
 func checkSystem() error {
 	// Establish system compatbility
-	switch runtime.GOOS {
-	case "darwin":
-	case "linux":
-		log.Warn("Linux detected - please ensure that socket capabilities have been set")
-	default:
+	supported := false
+	for _, platform := range platforms {
+		supported = supported || platform == runtime.GOOS
+	}
+	if !supported {
 		log.Error(fmt.Sprintf("'%s' testlet not supported on %s", testletName, runtime.GOOS))
 		return errors.New("unsupported platform")
 	}
+	if runtime.GOOS == "linux" {
+		log.Warn("Linux detected - please ensure that socket capabilities have been set")
+	}
 	return nil
 }
 
 func check() error {
 	err := checkSystem()
 	if err != nil {
-		os.Exit(1)
+		return err
 	}
 
 	loopbacks := []string{
@@ -50,6 +60,7 @@ func check() error {
 	}
 
 	successes := 0
+	var lastErr error
 
 	var pt = ping.PingTestlet{}
 	for i := range loopbacks {
@@ -58,7 +69,8 @@ func check() error {
 			"icmpTimeout": 3,
 		}, 1)
 		if err != nil {
-			log.Error("Problem sending test echo request: %v", err)
+			log.Errorf("Problem sending test echo request: %v", err)
+			lastErr = err
 			continue
 		}
 
@@ -71,6 +83,9 @@ func check() error {
 	}
 
 	if successes == 0 {
+		if lastErr != nil {
+			return lastErr
+		}
 		return errors.New("Not enough successful pings. Check failed.")
 	}
 
@@ -79,12 +94,21 @@ func check() error {
 
 func main() {
 
+	// Logging goes to stderr, leaving stdout for the results
+	log.SetOutput(os.Stderr)
+
 	app := cli.NewApp()
 	app.Name = "toddping"
-	app.Version = "v0.1.0"
+	app.Version = version
 	app.Usage = "A testlet for ICMP echos (ping)"
+	app.ArgsUsage = "[target...]"
 
-	var count, icmpTimeout int
+	var count, icmpTimeout, multicastTTL, sweepWorkers, sweepRate, port, interval, jitter, scheduleSeed int
+	var size, ttl, dscp int
+	var multiResponder, sweep, ndp, ndpUnicast, probe, probeRemote, timestamp, lateNotLost, records bool
+	var probeInterface, protocol, schedule, format, influxdb, graphite, statsd, metricPrefix string
+	var source, iface, family, targetsFile string
+	tags := &cli.StringSlice{}
 
 	// global level flags
 	app.Flags = []cli.Flag{
@@ -100,6 +124,177 @@ func main() {
 			Value:       3,
 			Destination: &icmpTimeout,
 		},
+		cli.IntFlag{
+			Name:        "i, interval",
+			Usage:       "average time between sending pings, in milliseconds",
+			Value:       1000,
+			Destination: &interval,
+		},
+		cli.StringFlag{
+			Name:        "schedule",
+			Usage:       "ping schedule: fixed (every --interval), poisson (exponentially distributed gaps) or uniform (gaps within --jitter of --interval)",
+			Value:       "fixed",
+			Destination: &schedule,
+		},
+		cli.IntFlag{
+			Name:        "jitter",
+			Usage:       "largest deviation from --interval for the uniform schedule, in milliseconds (default half the interval)",
+			Value:       -1,
+			Destination: &jitter,
+		},
+		cli.IntFlag{
+			Name:        "schedule-seed",
+			Usage:       "seed for random schedules, to make them reproducible (default: current time)",
+			Destination: &scheduleSeed,
+		},
+		cli.StringFlag{
+			Name:        "f, targets-file",
+			Usage:       "also ping the targets listed in a file, one per line (- for stdin)",
+			Destination: &targetsFile,
+		},
+		cli.IntFlag{
+			Name:        "s, size",
+			Usage:       "bytes of data carried by each echo request",
+			Value:       20,
+			Destination: &size,
+		},
+		cli.IntFlag{
+			Name:        "ttl",
+			Usage:       "TTL (IPv4) or hop limit (IPv6) of echo requests (default: the system's)",
+			Destination: &ttl,
+		},
+		cli.IntFlag{
+			Name:        "Q, dscp",
+			Usage:       "DSCP value (0-63) marked on echo requests",
+			Destination: &dscp,
+		},
+		cli.StringFlag{
+			Name:        "S, source",
+			Usage:       "source address to send echo requests from",
+			Destination: &source,
+		},
+		cli.StringFlag{
+			Name:        "I, interface",
+			Usage:       "name of the interface to send echo requests through",
+			Destination: &iface,
+		},
+		cli.StringFlag{
+			Name:        "family",
+			Usage:       "address family to resolve hostnames to: any, ipv4 or ipv6",
+			Value:       "any",
+			Destination: &family,
+		},
+		cli.BoolFlag{
+			Name:        "m, multi",
+			Usage:       "collect replies from every responder (for broadcast and multicast targets)",
+			Destination: &multiResponder,
+		},
+		cli.IntFlag{
+			Name:        "multicast-ttl",
+			Usage:       "TTL / hop limit for multicast requests in multi-responder mode",
+			Value:       1,
+			Destination: &multicastTTL,
+		},
+		cli.BoolFlag{
+			Name:        "sweep",
+			Usage:       "treat the target as a CIDR prefix (or @file of addresses) and report which hosts are alive",
+			Destination: &sweep,
+		},
+		cli.IntFlag{
+			Name:        "sweep-workers",
+			Usage:       "number of hosts probed concurrently in sweep mode",
+			Value:       32,
+			Destination: &sweepWorkers,
+		},
+		cli.IntFlag{
+			Name:        "sweep-rate",
+			Usage:       "maximum echo requests sent per second in sweep mode",
+			Value:       100,
+			Destination: &sweepRate,
+		},
+		cli.BoolFlag{
+			Name:        "ndp",
+			Usage:       "probe an on-link IPv6 target with neighbor solicitations instead of echo requests",
+			Destination: &ndp,
+		},
+		cli.BoolFlag{
+			Name:        "ndp-unicast",
+			Usage:       "send neighbor solicitations to the target itself rather than its solicited-node multicast address",
+			Destination: &ndpUnicast,
+		},
+		cli.BoolFlag{
+			Name:        "probe",
+			Usage:       "query the state of an interface on the target with RFC 8335 extended echo (PROBE)",
+			Destination: &probe,
+		},
+		cli.StringFlag{
+			Name:        "probe-interface",
+			Usage:       "interface to query in probe mode, by name, ifIndex or address",
+			Destination: &probeInterface,
+		},
+		cli.BoolFlag{
+			Name:        "probe-remote",
+			Usage:       "the probed interface belongs to a neighbor of the target, rather than the target itself",
+			Destination: &probeRemote,
+		},
+		cli.BoolFlag{
+			Name:        "timestamp",
+			Usage:       "also send ICMP timestamp requests (IPv4 only) to estimate one-way delays and clock offset",
+			Destination: &timestamp,
+		},
+		cli.BoolFlag{
+			Name:        "late-not-lost",
+			Usage:       "don't count echo replies that arrive after the timeout towards packet loss",
+			Destination: &lateNotLost,
+		},
+		cli.StringFlag{
+			Name:        "format",
+			Usage:       "output format: json (the results once the run is over), jsonl (a line per probe event as it happens, then the results), prometheus, openmetrics or text (like the classic ping command)",
+			Value:       "json",
+			Destination: &format,
+		},
+		cli.StringFlag{
+			Name:        "influxdb",
+			Usage:       "also push the metrics to InfluxDB, given the URL of its write API (http://host:8086/write?db=todd) or a UDP listener (udp://host:8089)",
+			Destination: &influxdb,
+		},
+		cli.StringFlag{
+			Name:        "graphite",
+			Usage:       "also push the metrics to Graphite's plaintext protocol at host:port (usually 2003)",
+			Destination: &graphite,
+		},
+		cli.StringFlag{
+			Name:        "statsd",
+			Usage:       "also push the metrics to StatsD at host:port (usually 8125) as gauges",
+			Destination: &statsd,
+		},
+		cli.StringFlag{
+			Name:        "metric-prefix",
+			Usage:       "prefix of the metric names sent to Graphite and StatsD",
+			Value:       "toddping",
+			Destination: &metricPrefix,
+		},
+		cli.StringSliceFlag{
+			Name:  "tag",
+			Usage: "key=value tag added to the metrics pushed to InfluxDB, Graphite and StatsD (may be repeated)",
+			Value: tags,
+		},
+		cli.BoolFlag{
+			Name:        "records",
+			Usage:       "include a record of every probe (send time, latency, responder, TTL, size and outcome) in the results",
+			Destination: &records,
+		},
+		cli.StringFlag{
+			Name:        "protocol",
+			Usage:       "probe protocol: icmp, tcp (connect to --port), udp (datagrams to --port, answered by an echo or port unreachable) or twamp (TWAMP-Light reflector on --port)",
+			Value:       "icmp",
+			Destination: &protocol,
+		},
+		cli.IntFlag{
+			Name:        "p, port",
+			Usage:       "destination port for tcp, udp and twamp probes (default 80 for tcp, 7 for udp, 862 for twamp)",
+			Destination: &port,
+		},
 	}
 
 	// ToDD Commands
@@ -113,43 +308,169 @@ func main() {
 				err := check()
 				if err != nil {
 					fmt.Println("Check mode FAILED")
-					os.Exit(1)
+					os.Exit(exitCode(err))
 				} else {
 					fmt.Println("Check mode PASSED")
 					os.Exit(0)
 				}
 			},
 		},
+
+		// "toddping describe"
+		{
+			Name:  "describe",
+			Usage: "Print a JSON description of the testlet: its args (as a JSON Schema), metrics and supported platforms",
+			Action: func(c *cli.Context) {
+				if err := describe(); err != nil {
+					log.Error(err)
+					os.Exit(exitInternal)
+				}
+			},
+		},
+
+		// "toddping serve ..."
+		{
+			Name:  "serve",
+			Usage: "Run a UDP echo (or TWAMP-Light) reflector, optionally impairing the reflected traffic",
+			Flags: serveFlags,
+			Action: func(c *cli.Context) {
+				if err := serve(c); err != nil {
+					log.Error(err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
+
+		// "toddping exporter ..."
+		{
+			Name:  "exporter",
+			Usage: "Serve ping runs over HTTP as Prometheus metrics (/probe?target=...&count=...), like the blackbox exporter",
+			Flags: exporterFlags,
+			Action: func(c *cli.Context) {
+				if err := runExporter(c); err != nil {
+					log.Error(err)
+					os.Exit(exitCode(err))
+				}
+			},
+		},
 	}
 
 	app.Action = func(c *cli.Context) {
 
-		var pt = ping.PingTestlet{}
-
-		argMap := map[string]interface{}{
-			"count":       count,
-			"icmpTimeout": icmpTimeout,
+		targets, err := readTargets(c.Args(), targetsFile)
+		if err != nil {
+			fail(format, "", &ping.RunError{Code: ping.CodeInvalidArgs, Err: err})
 		}
 
-		metrics, err := pt.Run(os.Args[1], argMap, 30)
+		out, err := newOutput(format, protocol, len(targets) > 1, os.Stdout)
 		if err != nil {
-			errorMessage := fmt.Sprintf("Native testlet '%s' completed with error '%s'", testletName, err)
-			log.Error(errorMessage)
-			fmt.Println(errorMessage)
-			//gatheredData[thisTarget] = "error"
-			os.Exit(1)
+			fail(format, "", &ping.RunError{Code: ping.CodeInvalidArgs, Err: err})
+		}
+
+		// Text output is for people, who'd rather not see every reply logged as well
+		if _, ok := out.(*textOutput); ok {
+			log.SetLevel(log.WarnLevel)
 		}
 
-		// The metrics infrastructure requires that we collect metrics as a JSON string
-		// (which is a result of building non-native testlets in early versions of ToDD)
-		// So let's convert, and add to gatheredData
-		metrics_json, err := json.Marshal(metrics)
+		sinks, err := newSinks(influxdb, graphite, statsd, metricPrefix, *tags)
 		if err != nil {
-			//TODO(mierdin) do something
+			fail(format, "", &ping.RunError{Code: ping.CodeInvalidArgs, Err: err})
+		}
+
+		var pt = ping.PingTestlet{Events: out.event}
+
+		argMap := map[string]interface{}{
+			"count":           count,
+			"icmpTimeout":     icmpTimeout,
+			"multi_responder": multiResponder,
+			"multicast_ttl":   multicastTTL,
+			"sweep":           sweep,
+			"sweep_workers":   sweepWorkers,
+			"sweep_rate":      sweepRate,
+			"ndp":             ndp,
+			"ndp_unicast":     ndpUnicast,
+			"probe":           probe,
+			"probe_interface": probeInterface,
+			"probe_local":     !probeRemote,
+			"timestamp":       timestamp,
+			"late_as_loss":    !lateNotLost,
+			"records":         records,
+			"protocol":        protocol,
+			"interval_ms":     interval,
+			"schedule":        schedule,
+			"schedule_seed":   scheduleSeed,
+			"size":            size,
+			"ttl":             ttl,
+			"dscp":            dscp,
+			"source":          source,
+			"interface":       iface,
+			"family":          family,
+		}
+		if port > 0 {
+			argMap["port"] = port
+		}
+		if jitter >= 0 {
+			argMap["jitter_ms"] = jitter
 		}
-		//gatheredData[thisTarget] = string(metrics_json)
-		fmt.Println(string(metrics_json))
+
+		// The latency histograms are built from the probe records (which sweeps don't keep)
+		if (format == formatPrometheus || format == formatOpenMetrics) && !sweep {
+			argMap["records"] = true
+		}
+
+		// The flags are checked just as a ToDD testrun's args would be, before pinging anything
+		if err := ping.ValidateArgs(argMap); err != nil {
+			fail(format, "", err)
+		}
+
+		// The first SIGINT or SIGTERM stops the run, which still reports what it measured so
+		// far; a second one gives up on that
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			log.Warnf("Received %v, stopping", sig)
+			cancel()
+			sig = <-signals
+			log.Warnf("Received %v again, exiting without results", sig)
+			os.Exit(exitInterrupted)
+		}()
+
+		// Targets are pinged one after the other. A target that can't be pinged is reported
+		// without stopping the rest.
+		var codes []int
+		for _, target := range targets {
+			if ctx.Err() != nil {
+				break
+			}
+
+			results, err := pt.RunContext(ctx, target, argMap, 30)
+			if err != nil {
+				codes = append(codes, reportError(format, target, err))
+				continue
+			}
+
+			push(sinks, target, results.Metrics, time.Now())
+
+			if err := out.results(target, results); err != nil {
+				fail(format, target, err)
+			}
+			codes = append(codes, lossExitCode(results))
+		}
+		if err := out.close(); err != nil {
+			fail(format, "", err)
+		}
+
+		if ctx.Err() != nil {
+			os.Exit(exitInterrupted)
+		}
+		os.Exit(combinedExitCode(codes))
 	}
 
-	app.Run(os.Args)
+	// Flags that can't be parsed are reported by the cli package, leaving just the exit status
+	if err := app.Run(os.Args); err != nil {
+		os.Exit(exitInvalidArgs)
+	}
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	cli "github.com/codegangsta/cli"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+// serveFlags configure the "serve" command
+var serveFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "l, listen",
+		Usage: "address to listen on (default :7, or :862 with --twamp)",
+	},
+	cli.BoolFlag{
+		Name:  "twamp",
+		Usage: "act as a stateless TWAMP-Light reflector (for --protocol twamp) rather than an echo server",
+	},
+	cli.DurationFlag{
+		Name:  "delay",
+		Usage: "delay added to every reflected datagram",
+	},
+	cli.DurationFlag{
+		Name:  "jitter",
+		Usage: "random variation of the delay, up to this much either way",
+	},
+	cli.Float64Flag{
+		Name:  "loss",
+		Usage: "probability (0-1) of dropping a datagram",
+	},
+	cli.Float64Flag{
+		Name:  "duplicate",
+		Usage: "probability (0-1) of reflecting a datagram twice",
+	},
+	cli.Float64Flag{
+		Name:  "reorder",
+		Usage: "probability (0-1) of holding a datagram back until after the next one",
+	},
+	cli.IntFlag{
+		Name:  "seed",
+		Usage: "seed for the impairment randomness, for reproducible runs (0 picks one)",
+	},
+	cli.DurationFlag{
+		Name:  "duration",
+		Usage: "stop after this long (0 runs until killed)",
+	},
+}
+
+// serve runs a UDP echo or TWAMP-Light reflector, so that probes can be validated against a
+// known-good responder
+func serve(c *cli.Context) error {
+
+	impairments := ping.Impairments{
+		Delay:     c.Duration("delay"),
+		Jitter:    c.Duration("jitter"),
+		Loss:      c.Float64("loss"),
+		Duplicate: c.Float64("duplicate"),
+		Reorder:   c.Float64("reorder"),
+		Seed:      int64(c.Int("seed")),
+	}
+
+	listen := c.String("listen")
+
+	var r *ping.Reflector
+	var err error
+	if c.Bool("twamp") {
+		if listen == "" {
+			listen = ":862"
+		}
+		r, err = ping.NewTWAMPReflector(listen, impairments)
+	} else {
+		if listen == "" {
+			listen = ":7"
+		}
+		r, err = ping.NewReflector(listen, impairments)
+	}
+	if err != nil {
+		return err
+	}
+
+	if d := c.Duration("duration"); d > 0 {
+		time.AfterFunc(d, func() {
+			r.Close()
+		})
+	}
+
+	err = r.Serve()
+
+	received, reflected := r.Stats()
+	log.Infof("Reflector stopped after receiving %d datagrams and reflecting %d", received, reflected)
+	return err
+}
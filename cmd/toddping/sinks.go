@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// sink pushes the metrics from each run to a time series database, alongside whatever toddping
+// prints. Every metric is tagged with the target, along with any tags given with --tag.
+type sink interface {
+	send(target string, metrics map[string]float32, at time.Time) error
+}
+
+// sinkTimeout limits how long pushing to a sink can take
+const sinkTimeout = 5 * time.Second
+
+// newSinks sets up the sinks chosen with --influxdb, --graphite and --statsd (each of which may
+// be empty), with series named under prefix where the protocol calls for it
+func newSinks(influxdb, graphite, statsd, prefix string, tags []string) ([]sink, error) {
+
+	parsed, err := parseTags(tags)
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks []sink
+	if influxdb != "" {
+		s, err := newInfluxSink(influxdb, parsed)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if graphite != "" {
+		sinks = append(sinks, &graphiteSink{addr: graphite, prefix: prefix, tags: parsed})
+	}
+	if statsd != "" {
+		sinks = append(sinks, &statsdSink{addr: statsd, prefix: prefix, tags: parsed})
+	}
+	return sinks, nil
+}
+
+// push sends the metrics of a run to every sink, logging any that fail. A run interrupted before
+// any probes went out has no metrics, and isn't pushed at all.
+func push(sinks []sink, target string, metrics map[string]float32, at time.Time) {
+	if len(metrics) == 0 {
+		return
+	}
+	for _, s := range sinks {
+		if err := s.send(target, metrics, at); err != nil {
+			log.Errorf("Failed to push metrics for %s: %v", target, err)
+		}
+	}
+}
+
+// parseTags parses tags given as key=value
+func parseTags(tags []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, tag := range tags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("tag '%s' isn't in the form key=value", tag)
+		}
+		if kv[0] == "target" {
+			return nil, errors.New("the target tag is always set, and can't be given with --tag")
+		}
+		parsed[kv[0]] = kv[1]
+	}
+	return parsed, nil
+}
+
+// sortedTags returns the keys of tags in order, so that output is repeatable
+func sortedTags(tags map[string]string) []string {
+	var keys []string
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedMetrics returns the names of metrics in order
+func sortedMetrics(metrics map[string]float32) []string {
+	var names []string
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// influxSink writes InfluxDB line protocol, either to the HTTP write API or a UDP listener. A
+// run becomes a single point in the "ping" measurement, with a field per metric.
+type influxSink struct {
+	url  *url.URL
+	tags map[string]string
+}
+
+// newInfluxSink sends to addr, which is either the full URL of the HTTP write endpoint
+// (http://influxdb:8086/write?db=todd) or udp://host:port
+func newInfluxSink(addr string, tags map[string]string) (*influxSink, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "udp":
+	default:
+		return nil, fmt.Errorf("InfluxDB address '%s' must be an http, https or udp URL", addr)
+	}
+	return &influxSink{url: u, tags: tags}, nil
+}
+
+// influxEscaper escapes tag keys, tag values and field keys
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func (s *influxSink) send(target string, metrics map[string]float32, at time.Time) error {
+
+	// A point needs at least one field, so there's nothing to write for a run without metrics
+	if len(metrics) == 0 {
+		return errors.New("no metrics to write to InfluxDB")
+	}
+
+	var b bytes.Buffer
+	b.WriteString("ping,target=" + influxEscaper.Replace(target))
+	for _, k := range sortedTags(s.tags) {
+		fmt.Fprintf(&b, ",%s=%s", influxEscaper.Replace(k), influxEscaper.Replace(s.tags[k]))
+	}
+	for i, k := range sortedMetrics(metrics) {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(&b, "%s%s=%v", sep, influxEscaper.Replace(k), metrics[k])
+	}
+	fmt.Fprintf(&b, " %d\n", at.UnixNano())
+
+	if s.url.Scheme == "udp" {
+		return sendDatagram(s.url.Host, b.Bytes())
+	}
+
+	client := http.Client{Timeout: sinkTimeout}
+	resp, err := client.Post(s.url.String(), "text/plain; charset=utf-8", &b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("InfluxDB returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// graphiteEscaper replaces the characters that can't appear in tags
+var graphiteEscaper = strings.NewReplacer(" ", "_", ";", "_", "~", "_", "=", "_")
+
+// graphiteSink writes the plaintext protocol over TCP, as a tagged series per metric
+// (prefix.metric;target=...;key=value)
+type graphiteSink struct {
+	addr   string
+	prefix string
+	tags   map[string]string
+}
+
+func (s *graphiteSink) send(target string, metrics map[string]float32, at time.Time) error {
+
+	var tags bytes.Buffer
+	fmt.Fprintf(&tags, ";target=%s", graphiteEscaper.Replace(target))
+	for _, k := range sortedTags(s.tags) {
+		fmt.Fprintf(&tags, ";%s=%s", graphiteEscaper.Replace(k), graphiteEscaper.Replace(s.tags[k]))
+	}
+
+	var b bytes.Buffer
+	for _, k := range sortedMetrics(metrics) {
+		fmt.Fprintf(&b, "%s.%s%s %v %d\n", s.prefix, k, tags.String(), metrics[k], at.Unix())
+	}
+
+	c, err := net.DialTimeout("tcp", s.addr, sinkTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.SetWriteDeadline(time.Now().Add(sinkTimeout))
+	_, err = c.Write(b.Bytes())
+	return err
+}
+
+// statsdEscaper replaces the characters that can't appear in tags
+var statsdEscaper = strings.NewReplacer(" ", "_", ",", "_", "|", "_", "#", "_")
+
+// statsdSink sends a gauge per metric over UDP, with tags in the DogStatsD style
+// (prefix.metric:value|g|#target:...,key:value)
+type statsdSink struct {
+	addr   string
+	prefix string
+	tags   map[string]string
+}
+
+func (s *statsdSink) send(target string, metrics map[string]float32, at time.Time) error {
+
+	tags := []string{"target:" + statsdEscaper.Replace(target)}
+	for _, k := range sortedTags(s.tags) {
+		tags = append(tags, statsdEscaper.Replace(k)+":"+statsdEscaper.Replace(s.tags[k]))
+	}
+
+	// One datagram per metric keeps each well under any MTU. A gauge with a leading '-' is taken
+	// as a decrement, so a negative value is set by zeroing the gauge first, in the same datagram
+	// so that the two can't be reordered.
+	for _, k := range sortedMetrics(metrics) {
+		name, suffix := s.prefix+"."+k, "|g|#"+strings.Join(tags, ",")
+		line := fmt.Sprintf("%s:%v%s", name, metrics[k], suffix)
+		if metrics[k] < 0 {
+			line = fmt.Sprintf("%s:0%s\n%s", name, suffix, line)
+		}
+		if err := sendDatagram(s.addr, []byte(line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendDatagram sends b to addr over UDP
+func sendDatagram(addr string, b []byte) error {
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	_, err = c.Write(b)
+	return err
+}
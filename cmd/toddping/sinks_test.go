@@ -0,0 +1,267 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	sinkMetrics = map[string]float32{"packet_loss": 0.25, "avg_latency_ms": 1.5}
+	sinkTime    = time.Unix(1500000000, 123)
+)
+
+// listenUDP opens a UDP socket on a free loopback port for the test, returning it and its address
+func listenUDP(t *testing.T) (net.PacketConn, string) {
+	t.Helper()
+
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, c.LocalAddr().String()
+}
+
+// readUDP reads n datagrams from c
+func readUDP(t *testing.T, c net.PacketConn, n int) []string {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1500)
+	var got []string
+	for len(got) < n {
+		l, _, err := c.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("after %q: %v", got, err)
+		}
+		got = append(got, string(b[:l]))
+	}
+	return got
+}
+
+func TestParseTags(t *testing.T) {
+	for _, tc := range []struct {
+		tags []string
+		want map[string]string // nil for an error
+	}{
+		{nil, map[string]string{}},
+		{[]string{"site=lon", "rack=a 1"}, map[string]string{"site": "lon", "rack": "a 1"}},
+		{[]string{"url=http://x/?a=b"}, map[string]string{"url": "http://x/?a=b"}},
+		{[]string{"site"}, nil},
+		{[]string{"=lon"}, nil},
+		{[]string{"site="}, nil},
+		{[]string{"site=lon", "target=x"}, nil},
+	} {
+		got, err := parseTags(tc.tags)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("parseTags(%q) = %v, want an error", tc.tags, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTags(%q): %v", tc.tags, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseTags(%q) = %v, want %v", tc.tags, got, tc.want)
+		}
+	}
+}
+
+func TestNewSinks(t *testing.T) {
+	sinks, err := newSinks("udp://127.0.0.1:8089", "127.0.0.1:2003", "127.0.0.1:8125", "todd", []string{"site=lon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sinks) != 3 {
+		t.Fatalf("got %d sinks, want 3", len(sinks))
+	}
+
+	if sinks, err := newSinks("", "", "", "todd", nil); err != nil || len(sinks) != 0 {
+		t.Errorf("newSinks with no addresses = %v, %v, want no sinks", sinks, err)
+	}
+	if _, err := newSinks("ftp://influxdb/", "", "", "todd", nil); err == nil {
+		t.Error("no error for an InfluxDB address that isn't http or udp")
+	}
+	if _, err := newSinks("", "", "127.0.0.1:8125", "todd", []string{"target=x"}); err == nil {
+		t.Error("no error for a target tag")
+	}
+}
+
+func TestInfluxSinkHTTP(t *testing.T) {
+	var got, query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		got, query = string(b), r.URL.RawQuery
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := newInfluxSink(srv.URL+"/write?db=todd", map[string]string{"site": "lon", "a b": "c,d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send("192.0.2.1", sinkMetrics, sinkTime); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `ping,target=192.0.2.1,a\ b=c\,d,site=lon avg_latency_ms=1.5,packet_loss=0.25 1500000000000000123` + "\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if query != "db=todd" {
+		t.Errorf("query %q, want db=todd", query)
+	}
+}
+
+func TestInfluxSinkHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	s, err := newInfluxSink(srv.URL+"/write?db=nope", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.send("192.0.2.1", sinkMetrics, sinkTime)
+	if err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("got error %v, want one passing on InfluxDB's", err)
+	}
+}
+
+func TestInfluxSinkUDP(t *testing.T) {
+	c, addr := listenUDP(t)
+
+	s, err := newInfluxSink("udp://"+addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send("host 1", sinkMetrics, sinkTime); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `ping,target=host\ 1 avg_latency_ms=1.5,packet_loss=0.25 1500000000000000123` + "\n"
+	if got := readUDP(t, c, 1); got[0] != want {
+		t.Errorf("got %q, want %q", got[0], want)
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b, _ := ioutil.ReadAll(c)
+		received <- string(b)
+	}()
+
+	s := &graphiteSink{addr: l.Addr().String(), prefix: "todd.ping", tags: map[string]string{"site": "l;on"}}
+	if err := s.send("192.0.2.1", sinkMetrics, sinkTime); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "todd.ping.avg_latency_ms;target=192.0.2.1;site=l_on 1.5 1500000000\n" +
+		"todd.ping.packet_loss;target=192.0.2.1;site=l_on 0.25 1500000000\n"
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing received")
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	c, addr := listenUDP(t)
+
+	s := &statsdSink{addr: addr, prefix: "todd", tags: map[string]string{"site": "lon", "rack": "a|1"}}
+	if err := s.send("192.0.2.1", sinkMetrics, sinkTime); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readUDP(t, c, 2)
+	sort.Strings(got)
+	want := []string{
+		"todd.avg_latency_ms:1.5|g|#target:192.0.2.1,rack:a_1,site:lon",
+		"todd.packet_loss:0.25|g|#target:192.0.2.1,rack:a_1,site:lon",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStatsdSinkNegative(t *testing.T) {
+	c, addr := listenUDP(t)
+
+	s := &statsdSink{addr: addr, prefix: "todd"}
+	if err := s.send("192.0.2.1", map[string]float32{"clock_offset_ms": -2.5}, sinkTime); err != nil {
+		t.Fatal(err)
+	}
+
+	// Zeroed first, as a leading '-' would otherwise decrement the gauge
+	want := "todd.clock_offset_ms:0|g|#target:192.0.2.1\ntodd.clock_offset_ms:-2.5|g|#target:192.0.2.1"
+	if got := readUDP(t, c, 1); got[0] != want {
+		t.Errorf("got %q, want %q", got[0], want)
+	}
+}
+
+func TestInfluxSinkNoMetrics(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	s, err := newInfluxSink(srv.URL+"/write?db=todd", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.send("192.0.2.1", map[string]float32{}, sinkTime); err == nil {
+		t.Error("no error for a point without fields")
+	}
+	if requests != 0 {
+		t.Errorf("%d requests made, want none", requests)
+	}
+}
+
+// recordingSink remembers what it was sent
+type recordingSink struct {
+	sent []map[string]float32
+}
+
+func (s *recordingSink) send(target string, metrics map[string]float32, at time.Time) error {
+	s.sent = append(s.sent, metrics)
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	s := &recordingSink{}
+
+	push([]sink{s}, "192.0.2.1", map[string]float32{}, sinkTime)
+	if len(s.sent) != 0 {
+		t.Errorf("run without metrics was pushed: %v", s.sent)
+	}
+
+	push([]sink{s}, "192.0.2.1", sinkMetrics, sinkTime)
+	if len(s.sent) != 1 || !reflect.DeepEqual(s.sent[0], sinkMetrics) {
+		t.Errorf("pushed %v, want %v", s.sent, sinkMetrics)
+	}
+}
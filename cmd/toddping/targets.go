@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readTargets returns the targets given as arguments, followed by those listed in file (one per
+// line, with '#' starting a comment). A file of "-" is read from stdin.
+func readTargets(args []string, file string) ([]string, error) {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			return nil, fmt.Errorf("flags must come before the targets (found '%s' after them)", arg)
+		}
+	}
+	targets := append([]string{}, args...)
+
+	if file != "" {
+		listed, err := readTargetFile(file)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, listed...)
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("no targets given")
+	}
+	return targets, nil
+}
+
+// readTargetFile reads the targets listed in file, or stdin for "-"
+func readTargetFile(file string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var targets []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			targets = append(targets, line)
+		}
+	}
+	return targets, scanner.Err()
+}
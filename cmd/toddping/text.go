@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+// textOutput prints a line per probe and a statistics summary per target, like the classic ping
+// command, for reading rather than parsing
+type textOutput struct {
+	w io.Writer
+
+	// seqLabel names sequence numbers in reply lines ("icmp_seq" for echo requests)
+	seqLabel string
+
+	// Events arrive from the run's goroutines
+	mu    sync.Mutex
+	stats map[string]*textStats
+}
+
+// textStats tallies the events for a single target
+type textStats struct {
+	start, end                   time.Time
+	sent, received, late, errors int
+	rtts                         []float64
+
+	// answered holds the probes replied to so far. Probes of a broadcast or multicast target can
+	// be answered by many hosts, but only the first reply counts as received.
+	answered map[int]bool
+}
+
+func newTextOutput(w io.Writer, protocol string) *textOutput {
+	label := "seq"
+	if protocol == "icmp" {
+		label = "icmp_seq"
+	}
+	return &textOutput{w: w, seqLabel: label, stats: map[string]*textStats{}}
+}
+
+func (o *textOutput) event(e ping.Event) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	st, ok := o.stats[e.Target]
+	if !ok {
+		st = &textStats{start: e.Time, answered: map[int]bool{}}
+		o.stats[e.Target] = st
+		fmt.Fprintf(o.w, "PING %s\n", e.Target)
+	}
+	st.end = e.Time
+
+	switch e.Type {
+	case ping.EventSent:
+		st.sent++
+	case ping.EventReply, ping.EventLate:
+		duplicate := false
+		if e.Type == ping.EventLate {
+			st.late++
+		} else if duplicate = st.answered[e.Seq]; !duplicate {
+			st.answered[e.Seq] = true
+			st.received++
+			st.rtts = append(st.rtts, float64(e.LatencyMs))
+		}
+
+		line := ""
+		if e.Size > 0 {
+			line = fmt.Sprintf("%d bytes ", e.Size)
+		}
+		line += fmt.Sprintf("from %s:", e.Responder)
+		if e.Host == "" {
+			line += fmt.Sprintf(" %s=%d", o.seqLabel, e.Seq)
+		}
+		if e.TTL > 0 {
+			line += fmt.Sprintf(" ttl=%d", e.TTL)
+		}
+		line += fmt.Sprintf(" time=%s ms", formatMs(float64(e.LatencyMs)))
+		if e.Type == ping.EventLate {
+			line += " (late)"
+		} else if duplicate {
+			line += " (DUP!)"
+		}
+		fmt.Fprintln(o.w, line)
+	case ping.EventTimeout:
+		fmt.Fprintf(o.w, "Request timeout for %s\n", o.probe(e, " "))
+	case ping.EventError:
+		st.errors++
+		if e.Responder != "" {
+			fmt.Fprintf(o.w, "From %s %s %s\n", e.Responder, o.probe(e, "="), e.Error)
+		} else {
+			fmt.Fprintf(o.w, "%s %s\n", o.probe(e, "="), e.Error)
+		}
+	}
+}
+
+// probe names the probe an event is about: by sequence number (after sep, as classic ping varies
+// it), or in sweep mode by the host
+func (o *textOutput) probe(e ping.Event, sep string) string {
+	if e.Host != "" {
+		return e.Host
+	}
+	return o.seqLabel + sep + strconv.Itoa(e.Seq)
+}
+
+func (o *textOutput) results(target string, r *ping.Results) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if st, ok := o.stats[target]; ok {
+		o.summarize(target, st, r.Interrupted)
+		o.details(r)
+		return nil
+	}
+
+	// A run interrupted before any probes went out has no events, so there's only the metrics to show
+	if r.Interrupted {
+		fmt.Fprintf(o.w, "--- %s ping statistics (interrupted) ---\n", target)
+	} else {
+		fmt.Fprintf(o.w, "--- %s ping statistics ---\n", target)
+	}
+	var names []string
+	for name := range r.Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(o.w, "%s: %v\n", name, r.Metrics[name])
+	}
+	return nil
+}
+
+func (o *textOutput) close() error { return nil }
+
+// details prints what the sweep, multi_responder, ndp and probe modes found beyond the probe
+// statistics. Must be called with o.mu held.
+func (o *textOutput) details(r *ping.Results) {
+	if r.Hosts != nil {
+		alive := 0
+		for _, h := range r.Hosts {
+			if h.Alive {
+				alive++
+			}
+		}
+		fmt.Fprintf(o.w, "%d hosts swept, %d alive\n", len(r.Hosts), alive)
+	}
+	for _, resp := range r.Responders {
+		fmt.Fprintf(o.w, "responder %s: %d replies, rtt min/avg/max = %.3f/%.3f/%.3f ms\n",
+			resp.Address, resp.Replies, resp.MinLatencyMs, resp.AvgLatencyMs, resp.MaxLatencyMs)
+	}
+	if r.LinkLayerAddress != "" {
+		fmt.Fprintf(o.w, "link-layer address %s\n", r.LinkLayerAddress)
+	}
+	if i := r.Interface; i != nil {
+		if i.Error != "" {
+			fmt.Fprintf(o.w, "interface query failed: %s\n", i.Error)
+		} else {
+			line := fmt.Sprintf("interface active=%t ipv4=%t ipv6=%t", i.Active, i.IPv4, i.IPv6)
+			if i.State != "" {
+				line += " state=" + i.State
+			}
+			fmt.Fprintln(o.w, line)
+		}
+	}
+}
+
+// summarize prints the statistics for one target. Must be called with o.mu held.
+func (o *textOutput) summarize(target string, st *textStats, interrupted bool) {
+	fmt.Fprintf(o.w, "\n--- %s ping statistics ---\n", target)
+
+	line := fmt.Sprintf("%d packets transmitted, %d received", st.sent, st.received)
+	if st.late > 0 {
+		line += fmt.Sprintf(", +%d late", st.late)
+	}
+	if st.errors > 0 {
+		line += fmt.Sprintf(", +%d errors", st.errors)
+	}
+	loss := 0.0
+	if st.sent > 0 {
+		loss = float64(st.sent-st.received) / float64(st.sent) * 100
+	}
+	line += fmt.Sprintf(", %s%% packet loss, time %dms", formatPercent(loss), st.end.Sub(st.start)/time.Millisecond)
+	if interrupted {
+		line += " (interrupted)"
+	}
+	fmt.Fprintln(o.w, line)
+
+	if len(st.rtts) == 0 {
+		return
+	}
+
+	// mdev is the standard deviation of the round trip times, as classic ping reports it
+	min, max := st.rtts[0], st.rtts[0]
+	var sum, sumSquares float64
+	for _, rtt := range st.rtts {
+		min = math.Min(min, rtt)
+		max = math.Max(max, rtt)
+		sum += rtt
+		sumSquares += rtt * rtt
+	}
+	n := float64(len(st.rtts))
+	avg := sum / n
+	mdev := math.Sqrt(math.Max(sumSquares/n-avg*avg, 0))
+
+	fmt.Fprintf(o.w, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", min, avg, max, mdev)
+}
+
+// formatMs prints a round trip time with the precision classic ping uses
+func formatMs(ms float64) string {
+	switch {
+	case ms >= 100:
+		return fmt.Sprintf("%.0f", ms)
+	case ms >= 10:
+		return fmt.Sprintf("%.1f", ms)
+	case ms >= 1:
+		return fmt.Sprintf("%.2f", ms)
+	}
+	return fmt.Sprintf("%.3f", ms)
+}
+
+// formatPercent prints a percentage without trailing zeroes
+func formatPercent(p float64) string {
+	return fmt.Sprintf("%.4g", p)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/toddproject/todd-nativetestlet-ping/ping"
+)
+
+func TestTextOutputMultiResponder(t *testing.T) {
+	var b bytes.Buffer
+	o := newTextOutput(&b, "icmp")
+
+	start := time.Unix(1500000000, 0)
+	for _, e := range []ping.Event{
+		{Type: ping.EventSent, Seq: 0, Time: start},
+		{Type: ping.EventReply, Seq: 0, Time: start, LatencyMs: 1, Responder: "192.0.2.1"},
+		{Type: ping.EventReply, Seq: 0, Time: start, LatencyMs: 2, Responder: "192.0.2.2"},
+		{Type: ping.EventSent, Seq: 1, Time: start.Add(time.Second)},
+		{Type: ping.EventTimeout, Seq: 1, Time: start.Add(2 * time.Second)},
+	} {
+		e.Target = "192.0.2.255"
+		o.event(e)
+	}
+	o.results("192.0.2.255", &ping.Results{Responders: []ping.Responder{
+		{Address: "192.0.2.1", Replies: 1, AvgLatencyMs: 1, MinLatencyMs: 1, MaxLatencyMs: 1},
+		{Address: "192.0.2.2", Replies: 1, AvgLatencyMs: 2, MinLatencyMs: 2, MaxLatencyMs: 2},
+	}})
+
+	want := `PING 192.0.2.255
+from 192.0.2.1: icmp_seq=0 time=1.00 ms
+from 192.0.2.2: icmp_seq=0 time=2.00 ms (DUP!)
+Request timeout for icmp_seq 1
+
+--- 192.0.2.255 ping statistics ---
+2 packets transmitted, 1 received, 50% packet loss, time 2000ms
+rtt min/avg/max/mdev = 1.000/1.000/1.000/0.000 ms
+responder 192.0.2.1: 1 replies, rtt min/avg/max = 1.000/1.000/1.000 ms
+responder 192.0.2.2: 1 replies, rtt min/avg/max = 2.000/2.000/2.000 ms
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestTextOutputSweep(t *testing.T) {
+	var b bytes.Buffer
+	o := newTextOutput(&b, "icmp")
+
+	start := time.Unix(1500000000, 0)
+	for _, e := range []ping.Event{
+		{Type: ping.EventSent, Seq: 0, Time: start, Host: "192.0.2.1"},
+		{Type: ping.EventSent, Seq: 1, Time: start, Host: "192.0.2.2"},
+		{Type: ping.EventReply, Seq: 0, Time: start, LatencyMs: 0.5, Responder: "192.0.2.1", TTL: 64, Size: 28, Host: "192.0.2.1"},
+		{Type: ping.EventError, Seq: 1, Time: start.Add(time.Second), Responder: "192.0.2.9", Error: "destination unreachable", Host: "192.0.2.2"},
+	} {
+		e.Target = "192.0.2.0/30"
+		o.event(e)
+	}
+	o.results("192.0.2.0/30", &ping.Results{Hosts: []ping.HostStatus{
+		{Address: "192.0.2.1", Alive: true, LatencyMs: 0.5},
+		{Address: "192.0.2.2"},
+	}})
+
+	got := b.String()
+	for _, want := range []string{
+		"28 bytes from 192.0.2.1: ttl=64 time=0.500 ms\n",
+		"From 192.0.2.9 192.0.2.2 destination unreachable\n",
+		"2 packets transmitted, 1 received, +1 errors, 50% packet loss, time 1000ms\n",
+		"2 hosts swept, 1 alive\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestTextOutputNoEvents(t *testing.T) {
+	var b bytes.Buffer
+	o := newTextOutput(&b, "icmp")
+
+	o.results("192.0.2.1", &ping.Results{Metrics: map[string]float32{}, Interrupted: true})
+
+	if got, want := b.String(), "--- 192.0.2.1 ping statistics (interrupted) ---\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
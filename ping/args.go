@@ -0,0 +1,267 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The args map handed to Run comes either from toddping's flags or from a ToDD testrun
+// definition (decoded from JSON/YAML), so optional values may arrive in a few shapes.
+
+// boolArg returns the named argument as a bool, or def if it isn't present
+func boolArg(args map[string]interface{}, name string, def bool) bool {
+	switch v := args[name].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return def
+}
+
+// intArg returns the named argument as an int, or def if it isn't present
+func intArg(args map[string]interface{}, name string, def int) int {
+	switch v := args[name].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return def
+}
+
+// stringArg returns the named argument as a string, or def if it isn't present
+func stringArg(args map[string]interface{}, name string, def string) string {
+	if v, ok := args[name].(string); ok {
+		return v
+	}
+	return def
+}
+
+// Address families, for the family arg
+const (
+	familyAny  = "any"
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// ArgDef describes one of the args Run accepts. Types are named as in JSON Schema, where an
+// integer may also be written as a float without a fractional part.
+type ArgDef struct {
+	Name     string
+	Type     string // "integer", "boolean" or "string"
+	Default  interface{}
+	Min, Max *int
+	Enum     []string
+	Required bool
+	Help     string
+}
+
+// Args lists every arg Run accepts, and is what ValidateArgs checks them against. Defaults are
+// nil where they depend on other args.
+var Args = []ArgDef{
+	{Name: "count", Type: "integer", Min: bound(1), Required: true, Help: "Number of probes to send"},
+	{Name: "icmpTimeout", Type: "integer", Min: bound(1), Required: true, Help: "Seconds to wait for the reply to each probe"},
+	{Name: "protocol", Type: "string", Default: "icmp", Enum: []string{"icmp", "tcp", "udp", "twamp"}, Help: "Probe protocol: ICMP echo, TCP connect, UDP datagrams or TWAMP-Light test packets"},
+	{Name: "port", Type: "integer", Min: bound(1), Max: bound(65535), Help: "Destination port for tcp, udp and twamp probes (default 80, 7 and 862 respectively)"},
+
+	// Scheduling
+	{Name: "interval_ms", Type: "integer", Default: 1000, Min: bound(0), Help: "Average time between probes, in milliseconds"},
+	{Name: "schedule", Type: "string", Default: scheduleFixed, Enum: []string{scheduleFixed, schedulePoisson, scheduleUniform}, Help: "How probes are spaced: every interval_ms, with exponentially distributed gaps, or with gaps within jitter_ms of interval_ms"},
+	{Name: "jitter_ms", Type: "integer", Min: bound(0), Help: "Largest deviation from interval_ms for the uniform schedule (default half of interval_ms)"},
+	{Name: "schedule_seed", Type: "integer", Default: 0, Help: "Seed for random schedules, to make them reproducible (0 uses the current time)"},
+
+	// Echo requests (icmp protocol, unicast targets)
+	{Name: "family", Type: "string", Default: familyAny, Enum: []string{familyAny, familyIPv4, familyIPv6}, Help: "Address family to use when resolving a target hostname (literal addresses must match it)"},
+	{Name: "size", Type: "integer", Default: minEchoSize, Min: bound(minEchoSize), Max: bound(maxEchoSize), Help: "Bytes of data carried by each echo request"},
+	{Name: "ttl", Type: "integer", Default: 0, Min: bound(0), Max: bound(255), Help: "TTL (IPv4) or hop limit (IPv6) of echo requests (0 for the system default)"},
+	{Name: "dscp", Type: "integer", Default: 0, Min: bound(0), Max: bound(63), Help: "DSCP value marked on echo requests"},
+	{Name: "source", Type: "string", Default: "", Help: "Source address to send echo requests from"},
+	{Name: "interface", Type: "string", Default: "", Help: "Name of the interface to send echo requests through"},
+	{Name: "late_as_loss", Type: "boolean", Default: true, Help: "Count echo replies that arrive after their timeout towards packet loss"},
+	{Name: "timestamp", Type: "boolean", Default: false, Help: "Also send ICMP timestamp requests (IPv4 only) to estimate one-way delays and clock offset"},
+	{Name: "records", Type: "boolean", Default: false, Help: "Include a record of every probe in the results"},
+
+	// Other modes
+	{Name: "multi_responder", Type: "boolean", Default: false, Help: "Collect replies from every responder (for broadcast and multicast targets)"},
+	{Name: "multicast_ttl", Type: "integer", Default: 1, Min: bound(1), Max: bound(255), Help: "TTL / hop limit for multicast requests in multi_responder mode"},
+	{Name: "sweep", Type: "boolean", Default: false, Help: "Treat the target as a CIDR prefix (or @file of addresses) and report which hosts are alive"},
+	{Name: "sweep_workers", Type: "integer", Default: 32, Min: bound(1), Help: "Hosts probed concurrently in sweep mode"},
+	{Name: "sweep_rate", Type: "integer", Default: 100, Min: bound(1), Max: bound(maxSweepRate), Help: "Maximum echo requests sent per second in sweep mode"},
+	{Name: "ndp", Type: "boolean", Default: false, Help: "Probe an on-link IPv6 target with neighbor solicitations instead of echo requests"},
+	{Name: "ndp_unicast", Type: "boolean", Default: false, Help: "Send neighbor solicitations to the target itself rather than its solicited-node multicast address"},
+	{Name: "probe", Type: "boolean", Default: false, Help: "Query the state of an interface on the target with RFC 8335 extended echo (PROBE)"},
+	{Name: "probe_interface", Type: "string", Default: "", Help: "Interface to query in probe mode, by name, ifIndex or address"},
+	{Name: "probe_local", Type: "boolean", Default: true, Help: "The probed interface belongs to the target itself, rather than one of its neighbors"},
+}
+
+func bound(n int) *int {
+	return &n
+}
+
+// ValidateArgs checks an args map against Args: every arg must be known and of the right type and
+// range, and the required ones present. Run does this itself, but it lets callers check a set of
+// args up front.
+func ValidateArgs(args map[string]interface{}) error {
+
+	var unknown []string
+	for name := range args {
+		if _, ok := argDef(name); !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return argError(fmt.Errorf("unknown args: %s", strings.Join(unknown, ", ")))
+	}
+
+	for _, def := range Args {
+		v, ok := args[def.Name]
+		if !ok {
+			if def.Required {
+				return argError(fmt.Errorf("%s is required", def.Name))
+			}
+			continue
+		}
+		if err := def.check(v); err != nil {
+			return argError(fmt.Errorf("%s %v", def.Name, err))
+		}
+	}
+	return nil
+}
+
+// argsSet returns those of names that args sets to something other than their defaults, for
+// turning away args that don't apply to a mode rather than silently ignoring them
+func argsSet(args map[string]interface{}, names ...string) []string {
+	var set []string
+	for _, name := range names {
+		def, _ := argDef(name)
+		if _, ok := args[name]; !ok {
+			continue
+		}
+
+		var v interface{}
+		switch def.Type {
+		case "boolean":
+			v = boolArg(args, name, false)
+		case "integer":
+			v = intArg(args, name, 0)
+		default:
+			v = stringArg(args, name, "")
+		}
+		if v != def.Default {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+// check validates a single value against the definition, accepting the same shapes as boolArg,
+// intArg and stringArg
+func (def ArgDef) check(v interface{}) error {
+	switch def.Type {
+	case "boolean":
+		switch b := v.(type) {
+		case bool:
+		case string:
+			if b != "true" && b != "false" {
+				return errors.New("must be a boolean")
+			}
+		default:
+			return errors.New("must be a boolean")
+		}
+
+	case "integer":
+		var n int
+		switch i := v.(type) {
+		case int:
+			n = i
+		case float64:
+			if i != math.Trunc(i) {
+				return errors.New("must be an integer")
+			}
+			n = int(i)
+		default:
+			return errors.New("must be an integer")
+		}
+		if def.Min != nil && n < *def.Min {
+			return fmt.Errorf("must be at least %d", *def.Min)
+		}
+		if def.Max != nil && n > *def.Max {
+			return fmt.Errorf("must be at most %d", *def.Max)
+		}
+
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		if len(def.Enum) == 0 {
+			return nil
+		}
+		for _, allowed := range def.Enum {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(def.Enum, ", "))
+	}
+	return nil
+}
+
+// argDef looks up the definition of an arg
+func argDef(name string) (ArgDef, bool) {
+	for _, def := range Args {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return ArgDef{}, false
+}
+
+// ArgsSchema returns a JSON Schema describing a valid args map, built from Args. It accepts
+// exactly what ValidateArgs does.
+func ArgsSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, def := range Args {
+		p := map[string]interface{}{
+			"type":        def.Type,
+			"description": def.Help,
+		}
+
+		// Booleans may also be given as strings, as boolArg accepts them
+		if def.Type == "boolean" {
+			p["type"] = []string{"boolean", "string"}
+			p["enum"] = []interface{}{true, false, "true", "false"}
+		}
+		if def.Default != nil {
+			p["default"] = def.Default
+		}
+		if def.Min != nil {
+			p["minimum"] = *def.Min
+		}
+		if def.Max != nil {
+			p["maximum"] = *def.Max
+		}
+		if def.Enum != nil {
+			p["enum"] = def.Enum
+		}
+		properties[def.Name] = p
+
+		if def.Required {
+			required = append(required, def.Name)
+		}
+	}
+
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,75 @@
+package ping
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args map[string]interface{}
+		err  string // empty if the args are valid
+	}{
+		{"required only", map[string]interface{}{"count": 3, "icmpTimeout": 1}, ""},
+		{"decoded from JSON", map[string]interface{}{"count": 3.0, "icmpTimeout": 1.0, "records": "true", "protocol": "udp", "port": 7.0}, ""},
+		{"bounds", map[string]interface{}{"count": 1, "icmpTimeout": 1, "ttl": 255, "dscp": 0, "port": 65535}, ""},
+		{"missing count", map[string]interface{}{"icmpTimeout": 1}, "count is required"},
+		{"missing timeout", map[string]interface{}{"count": 3}, "icmpTimeout is required"},
+		{"unknown args", map[string]interface{}{"count": 3, "icmpTimeout": 1, "zeta": 1, "alpha": 2}, "unknown args: alpha, zeta"},
+		{"fractional integer", map[string]interface{}{"count": 2.5, "icmpTimeout": 1}, "count must be an integer"},
+		{"string integer", map[string]interface{}{"count": "3", "icmpTimeout": 1}, "count must be an integer"},
+		{"below minimum", map[string]interface{}{"count": 0, "icmpTimeout": 1}, "count must be at least 1"},
+		{"above maximum", map[string]interface{}{"count": 3, "icmpTimeout": 1, "ttl": 256}, "ttl must be at most 255"},
+		{"invalid boolean", map[string]interface{}{"count": 3, "icmpTimeout": 1, "sweep": "yes"}, "sweep must be a boolean"},
+		{"numeric boolean", map[string]interface{}{"count": 3, "icmpTimeout": 1, "sweep": 1}, "sweep must be a boolean"},
+		{"not in enum", map[string]interface{}{"count": 3, "icmpTimeout": 1, "protocol": "sctp"}, "protocol must be one of icmp, tcp, udp, twamp"},
+		{"non-string", map[string]interface{}{"count": 3, "icmpTimeout": 1, "source": 1}, "source must be a string"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateArgs(tc.args)
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no error, want %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error %q, want %q", err, tc.err)
+			}
+			if code := ErrorCode(err); code != CodeInvalidArgs {
+				t.Errorf("ErrorCode = %s, want %s", code, CodeInvalidArgs)
+			}
+		})
+	}
+}
+
+func TestArgDefaults(t *testing.T) {
+	for _, def := range Args {
+		if def.Default == nil {
+			continue
+		}
+		if err := def.check(def.Default); err != nil {
+			t.Errorf("default for %s %v", def.Name, err)
+		}
+	}
+}
+
+func TestArgsSet(t *testing.T) {
+	args := map[string]interface{}{
+		"ttl":     0,    // the default
+		"dscp":    46.0, // decoded from JSON
+		"records": "true",
+		"family":  "any", // the default
+		"source":  "192.0.2.1",
+	}
+	got := argsSet(args, "ttl", "dscp", "records", "family", "source", "interface")
+	want := []string{"dscp", "records", "source"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Error codes classify why a run failed, so that callers (such as the ToDD agent) can tell a bad
+// testrun or a testlet that can't work on this host from a target that simply didn't answer.
+// They're stable identifiers, unlike the error messages.
+const (
+	CodeInvalidArgs = "invalid_args"      // the args or target given can't be run
+	CodePermission  = "permission_denied" // the sockets needed can't be opened
+	CodeInternal    = "internal_error"    // anything else
+)
+
+// RunError is an error from a run, along with its code
+type RunError struct {
+	Code string
+	Err  error
+}
+
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+// ErrorCode returns the code of an error returned by a run (or by the reflectors). Errors that
+// weren't classified are internal ones, unless they're down to missing permissions.
+func ErrorCode(err error) string {
+	if re, ok := err.(*RunError); ok {
+		return re.Code
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return CodePermission
+	}
+	return CodeInternal
+}
+
+// argError flags err as being down to the args or target given
+func argError(err error) error {
+	if _, ok := err.(*RunError); ok {
+		return err
+	}
+	return &RunError{Code: CodeInvalidArgs, Err: err}
+}
+
+// socketError classifies a failure to open a socket, which is usually down to missing privileges
+// or capabilities
+func socketError(err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return &RunError{Code: CodePermission, Err: fmt.Errorf("unable to open an ICMP socket (%w); please ensure socket capabilities have been set", err)}
+	}
+	return &RunError{Code: CodeInternal, Err: err}
+}
+
+// rawSocketRequired is returned by modes that only work with raw sockets, when only datagram ones
+// could be opened
+func rawSocketRequired(what string) error {
+	return &RunError{
+		Code: CodePermission,
+		Err:  errors.New(what + " requires a raw socket; please ensure socket capabilities have been set"),
+	}
+}
@@ -0,0 +1,67 @@
+package ping
+
+import (
+	"time"
+)
+
+// Event types, reported as a run progresses
+const (
+	EventSent    = "sent"
+	EventReply   = "reply"
+	EventTimeout = "timeout"
+	EventLate    = "late"  // a reply to a request that had already timed out
+	EventError   = "error" // an ICMP error in response to a request, or a failure to send it
+)
+
+// Event is something that happened during a run, reported through PingTestlet.Events as soon as
+// it happens
+type Event struct {
+	Type      string    `json:"event"`
+	Target    string    `json:"target"`
+	Seq       int       `json:"seq"`
+	Time      time.Time `json:"time"`
+	LatencyMs float32   `json:"latency_ms,omitempty"`
+	Responder string    `json:"responder,omitempty"`
+	TTL       int       `json:"ttl,omitempty"`
+	Size      int       `json:"size,omitempty"`
+	Error     string    `json:"error,omitempty"`
+
+	// Host is the address probed in sweep mode, where Target is what's being swept and Seq is the
+	// host's index among the addresses swept
+	Host string `json:"host,omitempty"`
+}
+
+// outcomeEvents maps a ProbeRecord's outcome to the event reporting it
+var outcomeEvents = map[string]string{
+	outcomeReply:   EventReply,
+	outcomeTimeout: EventTimeout,
+	outcomeLate:    EventLate,
+	outcomeError:   EventError,
+}
+
+// recordEvent is the event reporting a probe's outcome
+func recordEvent(target string, r ProbeRecord) Event {
+	return Event{
+		Type:      outcomeEvents[r.Outcome],
+		Target:    target,
+		Seq:       r.Seq,
+		Time:      time.Now(),
+		LatencyMs: r.LatencyMs,
+		Responder: r.Responder,
+		TTL:       r.TTL,
+		Size:      r.Size,
+		Error:     r.Error,
+	}
+}
+
+// lateEvent reports a late reply from target
+func lateEvent(target string, l LateReply) Event {
+	return Event{
+		Type:      EventLate,
+		Target:    target,
+		Seq:       l.Seq,
+		Time:      time.Now(),
+		LatencyMs: l.LatencyMs,
+		Responder: target,
+	}
+}
@@ -0,0 +1,127 @@
+package ping
+
+// lossPattern describes how the losses in a run were distributed, from whether each probe (in
+// the order they were sent) was answered. A burst is a run of consecutive losses, and a gap is
+// the run of replies between two bursts (as with the loss periods and loss distances of RFC 3357).
+//
+// The series is also fitted to the Gilbert-Elliott model, by fitGilbert.
+func lossPattern(received []bool) map[string]float32 {
+
+	var bursts, gaps []int
+	run := 0
+	for i, ok := range received {
+		run++
+		if i+1 < len(received) && received[i+1] == ok {
+			continue
+		}
+
+		// The run ends here
+		if !ok {
+			bursts = append(bursts, run)
+		} else if len(bursts) > 0 && i+1 < len(received) {
+			gaps = append(gaps, run)
+		}
+		run = 0
+	}
+
+	maxBurst := 0
+	for _, b := range bursts {
+		if b > maxBurst {
+			maxBurst = b
+		}
+	}
+
+	metrics := map[string]float32{
+		"loss_bursts":     float32(len(bursts)),
+		"loss_burst_max":  float32(maxBurst),
+		"loss_burst_mean": mean(bursts),
+		"loss_gap_mean":   mean(gaps),
+	}
+	metrics["ge_p"], metrics["ge_r"], metrics["ge_k"], metrics["ge_h"] = fitGilbert(received)
+	return metrics
+}
+
+// fitGilbert fits a series of answered and lost probes to the Gilbert-Elliott model, returning
+// the probabilities of moving from the good state to the bad one (p) and back (r), and of a
+// probe being answered in the good state (k) and in the bad state (h).
+//
+// The fit uses Gilbert's method, which takes k to be 1 (only the bad state loses probes) and
+// solves for p, r and h from the loss rate a and the probabilities of a loss being followed by
+// another one probe later (b) and two probes later (d):
+//
+//	b = (1-r)(1-h)
+//	d = ((1-r)² + rp)(1-h)
+//	a = p(1-h) / (p+r)
+//
+// Where that has no solution, as for a run without losses or one whose losses are no more
+// bursty than chance, the simple Gilbert model is fitted instead: h is taken to be 0 too, so
+// that every loss is in the bad state, and p and r are how often a reply was followed by a loss
+// and a loss by a reply. Either is 0 when the run gives nothing to estimate it from, such as r
+// for a run without losses.
+func fitGilbert(received []bool) (p, r, k, h float32) {
+
+	// Transitions between answered and lost probes, and from a loss to the probe two after it
+	var losses, goodToBad, fromGood, badToGood, fromBad, badToBadTwoOn, fromBadTwoOn int
+	for i, ok := range received {
+		if !ok {
+			losses++
+		}
+		if i+1 < len(received) {
+			if ok {
+				fromGood++
+				if !received[i+1] {
+					goodToBad++
+				}
+			} else {
+				fromBad++
+				if received[i+1] {
+					badToGood++
+				}
+			}
+		}
+		if i+2 < len(received) && !ok {
+			fromBadTwoOn++
+			if !received[i+2] {
+				badToBadTwoOn++
+			}
+		}
+	}
+
+	if fromBadTwoOn > 0 {
+		a := float64(losses) / float64(len(received))
+		b := float64(fromBad-badToGood) / float64(fromBad)
+		d := float64(badToBadTwoOn) / float64(fromBadTwoOn)
+
+		// 1-h, the probability of a probe being lost in the bad state
+		if d > a {
+			l := (b*b - 2*a*b + a*d) / (d - a)
+			if l > a && l >= b && l < 1 {
+				r := 1 - b/l
+				p := a * r / (l - a)
+				if r > 0 && p <= 1 {
+					return float32(p), float32(r), 1, float32(1 - l)
+				}
+			}
+		}
+	}
+
+	if fromGood > 0 {
+		p = float32(goodToBad) / float32(fromGood)
+	}
+	if fromBad > 0 {
+		r = float32(badToGood) / float32(fromBad)
+	}
+	return p, r, 1, 0
+}
+
+// mean is the average of a list of lengths, or 0 if there aren't any
+func mean(lengths []int) float32 {
+	if len(lengths) == 0 {
+		return 0
+	}
+	total := 0
+	for _, l := range lengths {
+		total += l
+	}
+	return float32(total) / float32(len(lengths))
+}
@@ -0,0 +1,93 @@
+package ping
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestLossPattern(t *testing.T) {
+	for _, tc := range []struct {
+		// Each probe in the order sent: '.' was answered, 'x' was lost
+		pattern string
+		want    map[string]float32
+	}{
+		{"", map[string]float32{}},
+		{".....", map[string]float32{}},
+		{"xxxx", map[string]float32{
+			"loss_bursts": 1, "loss_burst_max": 4, "loss_burst_mean": 4,
+		}},
+		{"x.x", map[string]float32{
+			"loss_bursts": 2, "loss_burst_max": 1, "loss_burst_mean": 1, "loss_gap_mean": 1,
+			"ge_p": 1, "ge_r": 1,
+		}},
+		// The replies before the first burst and after the last aren't gaps
+		{"..x..xx..", map[string]float32{
+			"loss_bursts": 2, "loss_burst_max": 2, "loss_burst_mean": 1.5, "loss_gap_mean": 2,
+			"ge_p": 2.0 / 5, "ge_r": 2.0 / 3,
+		}},
+		{"xx.xxx...x", map[string]float32{
+			"loss_bursts": 3, "loss_burst_max": 3, "loss_burst_mean": 2, "loss_gap_mean": 2,
+			"ge_p": 2.0 / 4, "ge_r": 2.0 / 5,
+		}},
+	} {
+		received := make([]bool, len(tc.pattern))
+		for i, c := range tc.pattern {
+			received[i] = c == '.'
+		}
+
+		got := lossPattern(received)
+
+		// None of these can be fitted with Gilbert's method, so get the simple Gilbert model
+		tc.want["ge_k"] = 1
+
+		for _, name := range []string{"loss_bursts", "loss_burst_max", "loss_burst_mean", "loss_gap_mean", "ge_p", "ge_r", "ge_k", "ge_h"} {
+			v, ok := got[name]
+			if !ok {
+				t.Errorf("%q: %s missing", tc.pattern, name)
+			} else if math.Abs(float64(v-tc.want[name])) > 1e-6 {
+				t.Errorf("%q: %s = %v, want %v", tc.pattern, name, v, tc.want[name])
+			}
+		}
+		if len(got) != 8 {
+			t.Errorf("%q: got %d metrics, want 8: %v", tc.pattern, len(got), got)
+		}
+	}
+}
+
+func TestFitGilbert(t *testing.T) {
+	for _, tc := range []struct {
+		p, r, h float64
+	}{
+		{0.05, 0.3, 0.2},
+		{0.01, 0.5, 0.5},
+		{0.2, 0.2, 0},
+	} {
+		// Simulate the model, starting in the good state
+		rnd := rand.New(rand.NewSource(1))
+		received := make([]bool, 1000000)
+		bad := false
+		for i := range received {
+			received[i] = !bad || rnd.Float64() < tc.h
+			if bad {
+				bad = rnd.Float64() >= tc.r
+			} else {
+				bad = rnd.Float64() < tc.p
+			}
+		}
+
+		p, r, k, h := fitGilbert(received)
+
+		if k != 1 {
+			t.Errorf("%+v: k = %v, want 1", tc, k)
+		}
+		for _, v := range []struct {
+			name      string
+			got, want float64
+		}{{"p", float64(p), tc.p}, {"r", float64(r), tc.r}, {"h", float64(h), tc.h}} {
+			if math.Abs(v.got-v.want) > 0.02+v.want/10 {
+				t.Errorf("%+v: %s = %v", tc, v.name, v.got)
+			}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ping
+
+// MetricDef describes one of the metrics the testlet can return
+type MetricDef struct {
+	Name string `json:"name"`
+	Unit string `json:"unit"` // "ms", "ratio" (between 0 and 1), "count" or "bool" (0 or 1)
+	Help string `json:"description"`
+}
+
+// Metrics lists every metric Run can return. Which ones are present depends on the mode and
+// protocol used.
+var Metrics = []MetricDef{
+	{"avg_latency_ms", "ms", "Average round trip time (lost probes count as 0 for icmp, tcp, udp and twamp)"},
+	{"packet_loss", "ratio", "Fraction of probes that weren't answered"},
+
+	// Echo sessions (icmp protocol, unicast targets)
+	{"late_replies", "count", "Echo replies that arrived after their request timed out"},
+	{"late_avg_latency_ms", "ms", "Average round trip time of late echo replies"},
+	{"duplicates", "count", "Duplicate echo replies (RFC 5560)"},
+	{"reordering_ratio", "ratio", "Fraction of echo replies that arrived out of order (RFC 4737)"},
+	{"reordering_extent", "count", "Largest reordering extent of any echo reply, in replies (RFC 4737)"},
+	{"kernel_rx_timestamps", "bool", "Whether echo replies were timed by the kernel on receipt"},
+	{"kernel_tx_timestamps", "bool", "Whether echo requests were timed by the kernel on sending"},
+
+	// Loss patterns
+	{"loss_bursts", "count", "Runs of consecutive lost probes"},
+	{"loss_burst_max", "count", "Longest run of consecutive lost probes"},
+	{"loss_burst_mean", "count", "Average length of runs of consecutive lost probes"},
+	{"loss_gap_mean", "count", "Average number of answered probes between runs of lost ones"},
+	{"ge_p", "ratio", "Gilbert-Elliott model probability of moving from the good state to the bad one"},
+	{"ge_r", "ratio", "Gilbert-Elliott model probability of moving from the bad state to the good one"},
+	{"ge_k", "ratio", "Gilbert-Elliott model probability of a probe being answered in the good state"},
+	{"ge_h", "ratio", "Gilbert-Elliott model probability of a probe being answered in the bad state"},
+
+	// ICMP timestamps and TWAMP
+	{"timestamp_loss", "ratio", "Fraction of ICMP timestamp requests that weren't answered"},
+	{"owd_forward_ms", "ms", "Average one-way delay to the target, including any clock offset"},
+	{"owd_reverse_ms", "ms", "Average one-way delay from the target, including any clock offset"},
+	{"clock_offset_ms", "ms", "Estimated offset of the target's clock from ours"},
+
+	// multi_responder
+	{"responder_count", "count", "Hosts that replied"},
+	{"replies", "count", "Echo replies received from all hosts"},
+
+	// sweep
+	{"hosts_swept", "count", "Hosts swept"},
+	{"hosts_alive", "count", "Hosts that replied"},
+	{"hosts_dead", "count", "Hosts that didn't reply"},
+
+	// probe
+	{"probe_errors", "count", "Extended echo replies reporting an error"},
+	{"interface_active", "bool", "Whether the probed interface is active"},
+	{"interface_ipv4", "bool", "Whether the probed interface has IPv4 enabled"},
+	{"interface_ipv6", "bool", "Whether the probed interface has IPv6 enabled"},
+}
+
+// metricDef returns the definition of the named metric, if there is one
+func metricDef(name string) (MetricDef, bool) {
+	for _, m := range Metrics {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return MetricDef{}, false
+}
@@ -0,0 +1,235 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Responder summarizes the echo replies received from a single host when pinging
+// a broadcast or multicast address in multi_responder mode
+type Responder struct {
+	Address      string  `json:"address"`
+	Replies      int     `json:"replies"`
+	AvgLatencyMs float32 `json:"avg_latency_ms"`
+	MinLatencyMs float32 `json:"min_latency_ms"`
+	MaxLatencyMs float32 `json:"max_latency_ms"`
+}
+
+// PingMulti sends a single echo request to target and, rather than stopping at the first
+// reply, collects every echo reply received before icmpTimeout expires. Broadcast is enabled
+// on the socket, and hops is used as the TTL / hop limit for multicast requests.
+// returns:
+// map[string]float32 - response time in milliseconds, keyed by responder address
+// error - nil if everything went well (no replies at all is not an error)
+func PingMulti(target string, seq, icmpTimeout, hops int) (map[string]float32, error) {
+	return pingMulti(context.Background(), target, seq, icmpTimeout, hops, nil)
+}
+
+// pingMulti is PingMulti, no longer collecting replies once ctx has been cancelled for
+// interruptGrace. If set, onReply is called with each responder's reply as it arrives.
+func pingMulti(ctx context.Context, target string, seq, icmpTimeout, hops int, onReply func(addr string, latency float32)) (map[string]float32, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := listen(t)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err := c.SetBroadcast(true); err != nil {
+		return nil, err
+	}
+	if t.IsMulticast() {
+		if err := c.SetMulticastHops(hops); err != nil {
+			return nil, err
+		}
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Duration(icmpTimeout) * time.Second))
+	defer cutShort(ctx, c)()
+
+	wb, err := echoRequest(t, os.Getpid()&0xffff, seq, minEchoSize)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.WriteTo(wb, t.addr(c.network)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	latencies := map[string]float32{}
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(rb)
+		if err != nil {
+			// Read deadline reached
+			break
+		}
+		elapsed := time.Since(start)
+
+		rm, err := icmp.ParseMessage(c.proto, rb[:n])
+		if err != nil {
+			log.Debugf("Ignoring unparseable message from %v: %v", peer, err)
+			continue
+		}
+
+		// Our own request may be looped back to us, so only replies count here
+		if rm.Type != ipv4.ICMPTypeEchoReply && rm.Type != ipv6.ICMPTypeEchoReply {
+			continue
+		}
+		echo, ok := rm.Body.(*icmp.Echo)
+		if !ok || echo.Seq != seq&0xffff || (c.raw() && echo.ID != os.Getpid()&0xffff) {
+			// Reply to somebody else's request
+			continue
+		}
+
+		addr := peerAddress(peer)
+		if _, ok := latencies[addr]; ok {
+			log.Debugf("Duplicate reply from %s", addr)
+			continue
+		}
+		latencies[addr] = float32(elapsed.Seconds() * 1e3)
+		if onReply != nil {
+			onReply(addr, latencies[addr])
+		}
+	}
+
+	return latencies, nil
+}
+
+// multiRun carries out count multi-responder probes towards target, one at a time as sched
+// allows, and aggregates the replies by responder
+func multiRun(ctx context.Context, target string, count, icmpTimeout, hops int, sched *schedule, emit func(Event)) (map[string]float32, []Responder, error) {
+
+	type tally struct {
+		replies         int
+		total, min, max float32
+	}
+	tallies := map[string]*tally{}
+
+	var replies, answered int
+	var latencyTotal float32
+
+	i := 0
+	for ; i < count && ctx.Err() == nil; i++ {
+		next := time.Now().Add(sched.next())
+		emit(Event{Type: EventSent, Seq: i, Time: time.Now()})
+
+		// Each responder's reply is reported as it arrives
+		latencies, err := pingMulti(ctx, target, i, icmpTimeout, hops, func(addr string, latency float32) {
+			emit(Event{Type: EventReply, Seq: i, Time: time.Now(), LatencyMs: latency, Responder: addr})
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(latencies) == 0 {
+			log.Info("Request timed out.")
+			emit(Event{Type: EventTimeout, Seq: i, Time: time.Now()})
+		} else {
+			log.Infof("%d replies received from %s", len(latencies), target)
+			answered++
+		}
+
+		for addr, latency := range latencies {
+			tl, ok := tallies[addr]
+			if !ok {
+				tl = &tally{min: latency, max: latency}
+				tallies[addr] = tl
+			}
+			tl.replies++
+			tl.total += latency
+			if latency < tl.min {
+				tl.min = latency
+			}
+			if latency > tl.max {
+				tl.max = latency
+			}
+
+			replies++
+			latencyTotal += latency
+		}
+
+		if i < count-1 {
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	// An interrupted run is summarized over the probes actually sent
+	if i < count {
+		log.Warnf("Interrupted after %d of %d probes", i, count)
+		count = i
+		if count == 0 {
+			return map[string]float32{}, nil, nil
+		}
+	}
+
+	responders := []Responder{}
+	for addr, tl := range tallies {
+		responders = append(responders, Responder{
+			Address:      addr,
+			Replies:      tl.replies,
+			AvgLatencyMs: tl.total / float32(tl.replies),
+			MinLatencyMs: tl.min,
+			MaxLatencyMs: tl.max,
+		})
+	}
+	sort.Sort(byAddress(responders))
+
+	var avgLatency float32
+	if replies > 0 {
+		avgLatency = latencyTotal / float32(replies)
+	}
+
+	metrics := map[string]float32{
+		"avg_latency_ms":  avgLatency,
+		"packet_loss":     (float32(count) - float32(answered)) / float32(count),
+		"responder_count": float32(len(responders)),
+		"replies":         float32(replies),
+	}
+
+	return metrics, responders, nil
+}
+
+// peerIP returns the source address of a reply, whichever kind of socket it arrived on
+func peerIP(peer net.Addr) net.IP {
+	switch a := peer.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// peerAddress renders the source of a reply without any port from datagram sockets
+func peerAddress(peer net.Addr) string {
+	switch a := peer.(type) {
+	case *net.IPAddr:
+		return a.String()
+	case *net.UDPAddr:
+		return (&net.IPAddr{IP: a.IP, Zone: a.Zone}).String()
+	}
+	return peer.String()
+}
+
+type byAddress []Responder
+
+func (r byAddress) Len() int           { return len(r) }
+func (r byAddress) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byAddress) Less(i, j int) bool { return r[i].Address < r[j].Address }
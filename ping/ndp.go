@@ -0,0 +1,286 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// Neighbor discovery option types (RFC 4861, section 4.6)
+const (
+	ndpOptSourceLinkLayerAddress = 1
+	ndpOptTargetLinkLayerAddress = 2
+)
+
+// Neighbor discovery messages must be sent with, and are only accepted with, the maximum hop limit
+const ndpHopLimit = 255
+
+// PingNDP sends an ICMPv6 Neighbor Solicitation for target and waits for the matching Neighbor
+// Advertisement. This reaches on-link hosts that filter echo requests, since they must answer
+// neighbor discovery. The solicitation goes to the target's solicited-node multicast address, or
+// straight to the target when unicast is set (as done when confirming reachability of a cached
+// neighbor). Requires a raw socket.
+// returns:
+// float32 - response time in milliseconds
+// net.HardwareAddr - the target's link-layer address, if advertised
+// bool - true if an advertisement was received before timeout
+// error - nil if everything went well
+func PingNDP(target string, icmpTimeout int, unicast bool) (float32, net.HardwareAddr, bool, error) {
+	return pingNDP(context.Background(), target, icmpTimeout, unicast)
+}
+
+// pingNDP is PingNDP, giving up on the advertisement once ctx has been cancelled for
+// interruptGrace
+func pingNDP(ctx context.Context, target string, icmpTimeout int, unicast bool) (float32, net.HardwareAddr, bool, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return 0.0, nil, false, err
+	}
+	if t.IsIPv4() || t.IsMulticast() {
+		return 0.0, nil, false, argError(errors.New("neighbor discovery requires a unicast IPv6 target"))
+	}
+
+	ifi, err := onLinkInterface(t)
+	if err != nil {
+		return 0.0, nil, false, argError(err)
+	}
+
+	c, err := listen(t)
+	if err != nil {
+		return 0.0, nil, false, err
+	}
+	defer c.Close()
+
+	if !c.raw() {
+		return 0.0, nil, false, rawSocketRequired("neighbor discovery")
+	}
+	if err := c.SetUnicastHops(ndpHopLimit); err != nil {
+		return 0.0, nil, false, err
+	}
+	if err := c.SetMulticastHops(ndpHopLimit); err != nil {
+		return 0.0, nil, false, err
+	}
+
+	// Advertisements are only valid with a hop limit of 255 (RFC 4861, section 7.1.2), which
+	// proves they weren't forwarded by a router
+	if err := setRecvHops(c.PacketConn); err != nil {
+		return 0.0, nil, false, err
+	}
+
+	dst := &net.IPAddr{IP: t.IP, Zone: ifi.Name}
+	if !unicast {
+		dst.IP = solicitedNodeAddress(t.IP)
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Duration(icmpTimeout) * time.Second))
+	defer cutShort(ctx, c)()
+
+	if _, err := c.WriteTo(neighborSolicitation(t.IP, ifi.HardwareAddr), dst); err != nil {
+		return 0.0, nil, false, err
+	}
+	start := time.Now()
+
+	rb := make([]byte, 1500)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, peer, err := c.readMsg(rb, oob)
+		if err != nil {
+			log.Debugf("Neighbor solicitation timeout on %v", t)
+			return 0.0, nil, false, nil
+		}
+		elapsed := time.Since(start)
+
+		rm, err := icmp.ParseMessage(c.proto, rb[:n])
+		if err != nil || rm.Type != ipv6.ICMPTypeNeighborAdvertisement || rm.Code != 0 {
+			continue
+		}
+		if hops := parseHops(oob[:oobn]); hops != ndpHopLimit {
+			log.Debugf("Ignoring neighbor advertisement from %v with hop limit %d", peer, hops)
+			continue
+		}
+		body, ok := rm.Body.(*icmp.DefaultMessageBody)
+		if !ok {
+			continue
+		}
+
+		advertised, lladdr, err := parseNeighborAdvertisement(body.Data)
+		if err != nil {
+			log.Debugf("Ignoring malformed neighbor advertisement from %v: %v", peer, err)
+			continue
+		}
+		if !advertised.Equal(t.IP) {
+			continue
+		}
+
+		return float32(elapsed.Seconds() * 1e3), lladdr, true, nil
+	}
+}
+
+// neighborSolicitation builds a Neighbor Solicitation for target, advertising our own
+// link-layer address so that the target can answer without soliciting us in turn
+func neighborSolicitation(target net.IP, lladdr net.HardwareAddr) []byte {
+
+	// 4 reserved bytes, then the target address
+	data := make([]byte, 4, 4+net.IPv6len+8)
+	data = append(data, target.To16()...)
+
+	if len(lladdr) > 0 {
+		data = append(data, ndpOption(ndpOptSourceLinkLayerAddress, lladdr)...)
+	}
+
+	wm := icmp.Message{
+		Type: ipv6.ICMPTypeNeighborSolicitation,
+		Code: 0,
+		Body: &icmp.DefaultMessageBody{Data: data},
+	}
+
+	// The checksum is filled in by the kernel for ICMPv6 raw sockets, and marshalling
+	// a DefaultMessageBody can't fail
+	wb, _ := wm.Marshal(nil)
+	return wb
+}
+
+// ndpOption encodes a neighbor discovery option, padded out to a multiple of 8 bytes
+func ndpOption(typ int, value []byte) []byte {
+	l := (2 + len(value) + 7) / 8 * 8
+	b := make([]byte, l)
+	b[0] = byte(typ)
+	b[1] = byte(l / 8)
+	copy(b[2:], value)
+	return b
+}
+
+// parseNeighborAdvertisement returns the target address of a Neighbor Advertisement body, along
+// with the link-layer address from its options (nil if none was included)
+func parseNeighborAdvertisement(b []byte) (net.IP, net.HardwareAddr, error) {
+
+	// 4 bytes of flags and reserved, then the target address
+	if len(b) < 4+net.IPv6len {
+		return nil, nil, errors.New("neighbor advertisement too short")
+	}
+	target := net.IP(b[4 : 4+net.IPv6len])
+
+	opts := b[4+net.IPv6len:]
+	for len(opts) >= 2 {
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			return nil, nil, errors.New("invalid neighbor discovery option length")
+		}
+		if opts[0] == ndpOptTargetLinkLayerAddress {
+			// Trim the padding for the common case of a 6-byte (Ethernet) address
+			lladdr := opts[2:l]
+			if l == 8 {
+				lladdr = lladdr[:6]
+			}
+			return target, net.HardwareAddr(append([]byte(nil), lladdr...)), nil
+		}
+		opts = opts[l:]
+	}
+	return target, nil, nil
+}
+
+// solicitedNodeAddress returns the solicited-node multicast address for ip (RFC 4291, section 2.7.1)
+func solicitedNodeAddress(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+	return addr
+}
+
+// onLinkInterface returns the interface t can be reached on directly: the one named by its zone,
+// or otherwise the first interface with a prefix that contains it
+func onLinkInterface(t Target) (*net.Interface, error) {
+	if t.Zone != "" {
+		return zoneInterface(t.Zone)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() != nil {
+				continue
+			}
+			if ipnet.Contains(t.IP) && !bytes.Equal(ipnet.Mask, net.CIDRMask(128, 128)) {
+				return &ifaces[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%s is not on-link; neighbor discovery needs a target on a local prefix (or a zone)", t)
+}
+
+// ndpRun carries out count neighbor discovery probes towards target, one at a time as sched allows
+func ndpRun(ctx context.Context, target string, count, icmpTimeout int, unicast bool, sched *schedule, emit func(Event)) (map[string]float32, string, error) {
+
+	var latencies []float32
+	var replies int
+	var lladdr net.HardwareAddr
+
+	i := 0
+	for ; i < count && ctx.Err() == nil; i++ {
+		next := time.Now().Add(sched.next())
+		emit(Event{Type: EventSent, Seq: i, Time: time.Now()})
+		latency, hwaddr, replyReceived, err := pingNDP(ctx, target, icmpTimeout, unicast)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if replyReceived {
+			log.Infof("Neighbor advertisement received from %s (%s) after %f ms", target, hwaddr, latency)
+			emit(Event{Type: EventReply, Seq: i, Time: time.Now(), LatencyMs: latency, Responder: target})
+			latencies = append(latencies, latency)
+			replies++
+			if hwaddr != nil {
+				lladdr = hwaddr
+			}
+		} else {
+			log.Info("Neighbor solicitation timed out.")
+			emit(Event{Type: EventTimeout, Seq: i, Time: time.Now()})
+		}
+
+		if i < count-1 {
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	// An interrupted run is summarized over the probes actually sent
+	if i < count {
+		log.Warnf("Interrupted after %d of %d probes", i, count)
+		count = i
+		if count == 0 {
+			return map[string]float32{}, "", nil
+		}
+	}
+
+	var latencyTotal, avgLatency float32
+	for _, value := range latencies {
+		latencyTotal += value
+	}
+	if replies > 0 {
+		avgLatency = latencyTotal / float32(replies)
+	}
+
+	metrics := map[string]float32{
+		"avg_latency_ms": avgLatency,
+		"packet_loss":    (float32(count) - float32(replies)) / float32(count),
+	}
+
+	return metrics, lladdr.String(), nil
+}
@@ -0,0 +1,93 @@
+package ping
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// neighborAdvertisement builds the body of a Neighbor Advertisement for target, followed by opts
+func neighborAdvertisement(target string, opts ...[]byte) []byte {
+	b := []byte{0x60, 0, 0, 0} // solicited and override
+	b = append(b, net.ParseIP(target).To16()...)
+	for _, opt := range opts {
+		b = append(b, opt...)
+	}
+	return b
+}
+
+func TestParseNeighborAdvertisement(t *testing.T) {
+	mac := []byte{0x02, 0x00, 0x5e, 0x10, 0x00, 0x01}
+	eui64 := []byte{0x02, 0x00, 0x5e, 0xff, 0xfe, 0x10, 0x00, 0x01}
+
+	for _, tc := range []struct {
+		name   string
+		body   []byte
+		lladdr net.HardwareAddr
+	}{
+		{"no options", neighborAdvertisement("fe80::1"), nil},
+		{"target link-layer address", neighborAdvertisement("fe80::1", ndpOption(ndpOptTargetLinkLayerAddress, mac)), mac},
+		{"after another option", neighborAdvertisement("fe80::1",
+			ndpOption(ndpOptSourceLinkLayerAddress, []byte{1, 2, 3, 4, 5, 6}),
+			ndpOption(ndpOptTargetLinkLayerAddress, mac)), mac},
+		// Only the padding of a 6-byte address can be told apart from the address itself
+		{"8-byte link-layer address", neighborAdvertisement("fe80::1", ndpOption(ndpOptTargetLinkLayerAddress, eui64)),
+			append(eui64, make([]byte, 6)...)},
+		{"trailing byte", append(neighborAdvertisement("fe80::1"), 0), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target, lladdr, err := parseNeighborAdvertisement(tc.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !target.Equal(net.ParseIP("fe80::1")) {
+				t.Errorf("target %s, want fe80::1", target)
+			}
+			if !bytes.Equal(lladdr, tc.lladdr) {
+				t.Errorf("link-layer address %v, want %v", lladdr, tc.lladdr)
+			}
+		})
+	}
+}
+
+func TestParseNeighborAdvertisementInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body []byte
+	}{
+		{"empty", nil},
+		{"short target", neighborAdvertisement("fe80::1")[:19]},
+		{"zero-length option", neighborAdvertisement("fe80::1", []byte{ndpOptTargetLinkLayerAddress, 0, 0, 0, 0, 0, 0, 0})},
+		{"option past the end", neighborAdvertisement("fe80::1", []byte{ndpOptTargetLinkLayerAddress, 2, 0, 0, 0, 0, 0, 0})},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := parseNeighborAdvertisement(tc.body); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestNDPOption(t *testing.T) {
+	got := ndpOption(ndpOptSourceLinkLayerAddress, []byte{1, 2, 3, 4, 5, 6})
+	want := []byte{ndpOptSourceLinkLayerAddress, 1, 1, 2, 3, 4, 5, 6}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Padded out to the next multiple of 8 bytes
+	if got := ndpOption(ndpOptSourceLinkLayerAddress, make([]byte, 8)); len(got) != 16 || got[1] != 2 {
+		t.Errorf("got %v, want 16 bytes with a length of 2", got)
+	}
+}
+
+func TestSolicitedNodeAddress(t *testing.T) {
+	for _, tc := range []struct{ ip, want string }{
+		{"fe80::1", "ff02::1:ff00:1"},
+		{"2001:db8::5e:ab12:3456", "ff02::1:ff12:3456"},
+	} {
+		if got := solicitedNodeAddress(net.ParseIP(tc.ip)); !got.Equal(net.ParseIP(tc.want)) {
+			t.Errorf("solicitedNodeAddress(%s) = %s, want %s", tc.ip, got, tc.want)
+		}
+	}
+}
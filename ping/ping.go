@@ -1,10 +1,11 @@
 package ping
 
 import (
+	"context"
 	"errors"
-	"net"
-	"os"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -17,41 +18,332 @@ import (
 
 type PingTestlet struct {
 	testing.BaseTestlet
+
+	// Events, if set, is called with each probe event (sent, reply, timeout, late or error) as the
+	// run progresses. Probes run concurrently, so it may be called from several goroutines, but
+	// never by more than one at a time.
+	Events func(Event)
 }
 
 // RunTestlet implements the general workflow of the testlet. Lower-level functionality is implemented by the downstream function;
 // this function focuses more on things like executing the right number of pings, and calculating metrics.
 // timeout is a generic arg for all testlets (primarily for server-style testlets)
 func (p PingTestlet) Run(target string, args map[string]interface{}, timeout int) (map[string]float32, error) {
+	results, err := p.RunDetailed(target, args, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return results.Metrics, nil
+}
+
+// RunDetailed is the same as Run, but returns the details gathered by modes such as
+// multi_responder, sweep, ndp and probe along with the metrics
+func (p PingTestlet) RunDetailed(target string, args map[string]interface{}, timeout int) (*Results, error) {
+	return p.RunContext(context.Background(), target, args, timeout)
+}
+
+// RunContext is the same as RunDetailed, but stops sending probes once ctx is cancelled, giving
+// any probe in flight a short grace period for its reply. The results then cover the probes sent
+// so far (or in sweep mode, the hosts), and are flagged as interrupted.
+func (p PingTestlet) RunContext(ctx context.Context, target string, args map[string]interface{}, timeout int) (*Results, error) {
 
 	// Get args
-	count := args["count"].(int)
-	icmpTimeout := args["icmpTimeout"].(int)
+	if err := ValidateArgs(args); err != nil {
+		return nil, err
+	}
+	count := intArg(args, "count", 0)
+	icmpTimeout := intArg(args, "icmpTimeout", 0)
+	protocol := stringArg(args, "protocol", "icmp")
+
+	// Hostnames are resolved to an address of the family asked for, which is what's probed; events
+	// still name the target as it was given
+	name := target
+	events := func(e Event) {
+		e.Target = name
+		p.emit(e)
+	}
+
+	// In sweep mode the target is a prefix or address file rather than a single address
+	if boolArg(args, "sweep", false) {
+		if set := argsSet(args, "protocol", "interval_ms", "schedule", "jitter_ms", "schedule_seed", "size", "ttl", "dscp", "source", "interface", "timestamp", "records"); len(set) > 0 {
+			return nil, argError(fmt.Errorf("not supported in sweep mode: %s", strings.Join(set, ", ")))
+		}
+		workers := intArg(args, "sweep_workers", 32)
+		rate := intArg(args, "sweep_rate", 100)
+		if workers < 1 || rate < 1 {
+			return nil, argError(errors.New("sweep_workers and sweep_rate must be at least 1"))
+		}
+		metrics, hosts, err := sweepRun(ctx, target, count, icmpTimeout, workers, rate, events)
+		if err != nil {
+			return nil, err
+		}
+		return &Results{Metrics: metrics, Hosts: hosts, Interrupted: ctx.Err() != nil}, nil
+	}
 
-	var latencies []float32
+	target, err := resolveTarget(name, stringArg(args, "family", familyAny))
+	if err != nil {
+		return nil, err
+	}
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := newSendOptions(args, t)
+	if err != nil {
+		return nil, err
+	}
+	// Every mode but sweep paces its probes by the schedule
+	sched, err := newSchedule(args)
+	if err != nil {
+		return nil, argError(err)
+	}
+
+	custom := len(argsSet(args, "size", "ttl", "dscp", "source", "interface")) > 0
+	if custom && (protocol != "icmp" || t.IsMulticast() || boolArg(args, "ndp", false) ||
+		boolArg(args, "probe", false) || boolArg(args, "multi_responder", false)) {
+		return nil, argError(errors.New("size, ttl, dscp, source and interface only apply to icmp echo requests to a unicast target"))
+	}
+
+	// Neighbor discovery stands in for echo, for on-link IPv6 hosts that filter it
+	if boolArg(args, "ndp", false) {
+		metrics, lladdr, err := ndpRun(ctx, target, count, icmpTimeout, boolArg(args, "ndp_unicast", false), sched, events)
+		if err != nil {
+			return nil, err
+		}
+		return &Results{Metrics: metrics, LinkLayerAddress: lladdr, Interrupted: ctx.Err() != nil}, nil
+	}
+
+	// RFC 8335 extended echo asks the target about one of its interfaces
+	if boolArg(args, "probe", false) {
+		iface := stringArg(args, "probe_interface", "")
+		metrics, status, err := probeRun(ctx, target, count, icmpTimeout, iface, boolArg(args, "probe_local", true), sched, events)
+		if err != nil {
+			return nil, err
+		}
+		return &Results{Metrics: metrics, Interface: status, Interrupted: ctx.Err() != nil}, nil
+	}
+
+	// Broadcast and multicast targets can be answered by many hosts, which is only
+	// accounted for when asked to
+	if boolArg(args, "multi_responder", false) {
+		metrics, responders, err := multiRun(ctx, target, count, icmpTimeout, intArg(args, "multicast_ttl", 1), sched, events)
+		if err != nil {
+			return nil, err
+		}
+		return &Results{Metrics: metrics, Responders: responders, Interrupted: ctx.Err() != nil}, nil
+	}
+
+	// Probes run concurrently, so mu guards everything they share below, and serializes events
+	var mu sync.Mutex
+
+	// One-way delay estimates, from ICMP timestamps or TWAMP
+	var samples []TimestampSample
+
+	// Where echo latencies were timed; if sources differ between replies, the least accurate is reported
+	var timestamping string
+
+	// Echo replies that arrived after their request timed out. They still count as lost, unless
+	// late_as_loss is false.
+	var late []LateReply
+	lateAsLoss := boolArg(args, "late_as_loss", true)
+
+	// Unicast echo requests are all sent over one socket, which tracks the replies' arrival.
+	// echoes holds the details of each reply.
+	var session *echoSession
+	echoes := make([]echoReply, count)
+
+	// Echo requests are used by default, but other protocols can stand in where ICMP is filtered
+	probe := func(seq int) (float32, bool, error) {
+		return pingNative(ctx, target, seq, icmpTimeout)
+	}
+	switch protocol {
+	case "icmp":
+		if !t.IsMulticast() {
+			var err error
+			if session, err = newEchoSession(t, opts); err != nil {
+				return nil, err
+			}
+			defer session.Close()
+
+			session.late = func(l LateReply) {
+				mu.Lock()
+				defer mu.Unlock()
+				log.Infof("Late reply received from %s for seq %d after %f ms", target, l.Seq, l.LatencyMs)
+				p.emit(lateEvent(name, l))
+				late = append(late, l)
+			}
+			probe = func(seq int) (float32, bool, error) {
+				reply, err := session.ping(seq, icmpTimeout)
+				echoes[seq] = reply
+				mu.Lock()
+				if reply.replied && (timestamping == "" || timestampRank[reply.timestamps] < timestampRank[timestamping]) {
+					timestamping = reply.timestamps
+				}
+				mu.Unlock()
+				return reply.latency, reply.replied, err
+			}
+		}
+	case "tcp":
+		port := intArg(args, "port", 80)
+		probe = func(seq int) (float32, bool, error) {
+			return pingTCP(ctx, target, port, icmpTimeout)
+		}
+	case "udp":
+		port := intArg(args, "port", 7)
+		probe = func(seq int) (float32, bool, error) {
+			return pingUDP(ctx, target, seq, port, icmpTimeout)
+		}
+	case "twamp":
+		port := intArg(args, "port", twampPort)
+		probe = func(seq int) (float32, bool, error) {
+			sample, err := pingTWAMP(ctx, target, seq, port, icmpTimeout)
+			if err != nil || sample == nil {
+				return 0.0, false, err
+			}
+			mu.Lock()
+			samples = append(samples, *sample)
+			mu.Unlock()
+			return sample.RTTMs, true, nil
+		}
+	default:
+		return nil, argError(fmt.Errorf("unsupported protocol '%s'", protocol))
+	}
+
+	// ICMP timestamp requests can be sent alongside the echoes, to estimate one-way delays
+	timestamps := boolArg(args, "timestamp", false)
+	if timestamps && !t.IsIPv4() {
+		return nil, argError(errors.New("ICMP timestamp requests are only defined for IPv4"))
+	}
+	if timestamps && protocol != "icmp" {
+		return nil, argError(errors.New("ICMP timestamp requests can only accompany icmp probes"))
+	}
+
+	// Only raw sockets can send timestamp requests, which is better found out before any probes
+	if timestamps {
+		if err := requireRawSocket(t, "sending ICMP timestamp requests"); err != nil {
+			return nil, err
+		}
+	}
+
+	// The outcome of each probe, by index. answered is kept for analysing the pattern of any losses.
+	latencies := make([]float32, count)
+	answered := make([]bool, count)
+	records := make([]ProbeRecord, count)
 	var replies int
 
-	// Execute ping once per count
-	i := 0
-	for i < count {
+	if session != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				session.interrupt(interruptGrace)
+			case <-done:
+			}
+		}()
+	}
 
-		latency, replyReceived, _ := PingNative(target, count, icmpTimeout)
-		//TODO(mierdin): handle err
+	// run carries out probe i, and records its outcome
+	run := func(i int, sent time.Time) {
+		latency, replyReceived, err := probe(i)
 
+		mu.Lock()
 		if replyReceived {
 			log.Infof("Reply received from %s after %f ms", target, latency)
+		} else if err != nil {
+			log.Infof("Request failed: %v", err)
 		} else {
 			log.Info("Request timed out.")
 		}
 
-		latencies = append(latencies, latency)
+		record := newRecord(i, sent, latency, replyReceived, err)
+		if replyReceived && session != nil {
+			record.Responder, record.Size = echoes[i].from, echoes[i].size
+			if echoes[i].ttl > 0 {
+				record.TTL = echoes[i].ttl
+			}
+		} else if replyReceived {
+			record.Responder = t.String()
+		}
+		records[i] = record
+		p.emit(recordEvent(name, record))
 
+		latencies[i] = latency
+		answered[i] = replyReceived
 		if replyReceived {
 			replies += 1
 		}
+		mu.Unlock()
+
+		if timestamps {
+			// A request that fails (such as one answered with non-standard timestamps) is lost,
+			// rather than losing the echo results as well
+			sample, err := pingTimestamp(ctx, target, i, icmpTimeout)
+			mu.Lock()
+			if err != nil {
+				log.Infof("Timestamp request failed: %v", err)
+			} else if sample != nil {
+				log.Infof("Timestamp reply from %s: forward %.0f ms, reverse %.0f ms", target, sample.ForwardMs, sample.ReverseMs)
+				samples = append(samples, *sample)
+			} else {
+				log.Info("Timestamp request timed out.")
+			}
+			mu.Unlock()
+		}
+	}
+
+	// Execute ping once per count. Probes are sent on schedule, whether or not earlier ones have
+	// been answered yet, so that losses don't hold back (or bunch up) the ones that follow. A gap
+	// of zero sends each probe as soon as the one before it is done.
+	var wg sync.WaitGroup
+	i := 0
+	for i < count && ctx.Err() == nil {
+		gap := sched.next()
+		next := time.Now().Add(gap)
+
+		sent := time.Now()
+		mu.Lock()
+		p.emit(Event{Type: EventSent, Target: name, Seq: i, Time: sent})
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			run(i, sent)
+		}(i)
+		if gap == 0 {
+			wg.Wait()
+		}
 
 		i += 1
-		time.Sleep(1000 * time.Millisecond)
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+		}
+	}
+	wg.Wait()
+
+	// An interrupted run is summarized over the probes actually sent
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		log.Warnf("Interrupted after %d of %d probes", i, count)
+		count = i
+		if count == 0 {
+			return &Results{Metrics: map[string]float32{}, Interrupted: true}, nil
+		}
+		latencies, answered, records = latencies[:count], answered[:count], records[:count]
+	}
+
+	// The wait after the last request gives its reply (and any duplicates) time to arrive, even
+	// if late
+	if session != nil {
+		session.drain()
+	}
+	for _, l := range late {
+		records[l.Seq].Outcome = outcomeLate
+		records[l.Seq].LatencyMs = l.LatencyMs
+		records[l.Seq].Responder = t.String()
 	}
 
 	// Calculate metrics
@@ -67,163 +359,128 @@ func (p PingTestlet) Run(target string, args map[string]interface{}, timeout int
 	// 	"packet_loss":    fmt.Sprintf("%.2f", packet_loss),
 	// }, nil
 
-	return map[string]float32{
+	metrics := map[string]float32{
 		"avg_latency_ms": avg_latency_ms,
 		"packet_loss":    packet_loss,
-	}, nil
+	}
+	if timestamps {
+		metrics["timestamp_loss"] = (float32(count) - float32(len(samples))) / float32(count)
+	}
+	if session != nil {
+		metrics["late_replies"] = float32(len(late))
+		if len(late) > 0 {
+			var lateTotal float32
+			for _, l := range late {
+				lateTotal += l.LatencyMs
+			}
+			metrics["late_avg_latency_ms"] = lateTotal / float32(len(late))
+		}
+		if !lateAsLoss {
+			metrics["packet_loss"] = (float32(count) - float32(replies+len(late))) / float32(count)
+			for _, l := range late {
+				answered[l.Seq] = true
+			}
+		}
+
+		metrics["duplicates"] = float32(session.duplicates)
+		ratio, extent := reordering(session.arrivals)
+		metrics["reordering_ratio"] = ratio
+		metrics["reordering_extent"] = float32(extent)
+	}
+	for k, v := range lossPattern(answered) {
+		metrics[k] = v
+	}
+	for k, v := range oneWayMetrics(samples) {
+		metrics[k] = v
+	}
+
+	// The timestamping source is also reported as metrics, for consumers that only read those
+	if timestamping != "" {
+		metrics["kernel_rx_timestamps"] = boolMetric(timestamping != timestampsUser)
+		metrics["kernel_tx_timestamps"] = boolMetric(timestamping == timestampsKernel)
+	}
 
+	results := &Results{Metrics: metrics, Timestamping: timestamping, Interrupted: interrupted}
+	if boolArg(args, "records", false) {
+		results.Probes = records
+	}
+	return results, nil
+
+}
+
+// emit reports an event, if anyone is listening
+func (p PingTestlet) emit(e Event) {
+	if p.Events != nil {
+		p.Events(e)
+	}
 }
 
-// PingNative is a Go implementation of ping
+// PingNative is a Go implementation of ping. target may carry an IPv6 zone
+// ("fe80::1%eth0"); for multicast targets (such as "ff02::1%eth0") all replies
+// received before the timeout are collected with PingMulti, and the lowest latency is returned.
 // returns:
 // float32 - response time in milliseconds
 // bool - true if reply recieved before timeout
 // error - nil if everything went well
 func PingNative(target string, count, icmpTimeout int) (float32, bool, error) {
+	return pingNative(context.Background(), target, count, icmpTimeout)
+}
 
-	var proto, addy string
-	var requestproto, replyproto int
+// pingNative is PingNative, giving up on the reply once ctx has been cancelled for interruptGrace
+func pingNative(ctx context.Context, target string, count, icmpTimeout int) (float32, bool, error) {
 
 	// Detect v4/v6
-	ip := net.ParseIP(target)
-
-	// TODO(mierdin): Need to fall back on udp ping if the icmp approach doesn't work.
-	// Was "udp4" and "udp6" before I decided to go the "capabilities" route
-
-	if ip.To4() != nil {
-		proto = "ip4:icmp"
-		addy = "0.0.0.0"
-		requestproto = 8
-		replyproto = 1
-	} else {
-		// proto = "ip6:icmp"
-		proto = "ip6:ipv6-icmp"
-		addy = "::"
-		// replyproto = 129
-		requestproto = 128
-		replyproto = 58
+	t, err := ParseTarget(target)
+	if err != nil {
+		return 0.0, false, err
 	}
 
-	// Start listening for response on all interfaces
-	// This will attempt a raw ICMP socket first, then fall back to UDP
-	c, err := icmp.ListenPacket(proto, addy)
-	if err != nil {
-		if proto == "ip4:icmp" {
-			proto = "udp4"
-		} else if proto == "ip6:ipv6-icmp" {
-			proto = "udp6"
+	if t.IsMulticast() {
+		latencies, err := pingMulti(ctx, target, count, icmpTimeout, 1, nil)
+		if err != nil || len(latencies) == 0 {
+			return 0.0, false, err
 		}
-		c, err = icmp.ListenPacket(proto, addy)
-		if err != nil {
-			log.Error("Failed to open a socket. Please refer to the documentation for system compatibility")
-			log.Fatal(err)
+		first := float32(-1)
+		for peer, latency := range latencies {
+			log.Debugf("Reply received from %s (via %s) after %f ms", peer, t, latency)
+			if first < 0 || latency < first {
+				first = latency
+			}
 		}
+		return first, true, nil
 	}
 
-	log.Debugf("Opened %s socket", proto)
-
-	// time.Sleep(time.Second * 100000)
-	defer c.Close()
-
-	// Set the timeout so the socket doesn't block forever
-	// (default is 3 seconds)
-	c.SetReadDeadline(time.Now().Add(time.Duration(icmpTimeout) * time.Second))
+	s, err := newEchoSession(t, sendOptions{})
+	if err != nil {
+		log.Error("Failed to open a socket. Please refer to the documentation for system compatibility")
+		return 0.0, false, err
+	}
+	defer s.Close()
+	defer context.AfterFunc(ctx, func() {
+		s.interrupt(interruptGrace)
+	})()
 
-	log.Debug(requestproto)
+	reply, err := s.ping(count, icmpTimeout)
+	return reply.latency, reply.replied, err
+}
 
-	// Construct ICMP echo
+// echoRequest builds an ICMP echo request for t, carrying the time it was built in a payload of
+// size bytes (see echoPayload). The identifier is only honoured on raw sockets; the kernel
+// substitutes its own for datagram sockets.
+func echoRequest(t Target, id, seq, size int) ([]byte, error) {
 	wm := icmp.Message{
-		// Code: requestproto,
 		Code: 0,
 		Body: &icmp.Echo{
-			ID: os.Getpid() & 0xffff, Seq: count,
-			Data: []byte("hanshotfirst"),
+			ID: id, Seq: seq,
+			Data: echoPayload(time.Now(), size),
 		},
 	}
 
-	if ip.To4() != nil {
+	if t.IsIPv4() {
 		wm.Type = ipv4.ICMPTypeEcho
 	} else {
 		wm.Type = ipv6.ICMPTypeEchoRequest
 	}
 
-	wb, err := wm.Marshal(nil)
-	if err != nil {
-		log.Error(err)
-		return 0.0, false, nil
-	}
-
-	if strings.Contains(proto, "udp") {
-		if _, err := c.WriteTo(wb, &net.UDPAddr{IP: net.ParseIP(target)}); err != nil {
-			log.Error(err)
-			return 0.0, false, nil
-		}
-	} else {
-		if _, err := c.WriteTo(wb, &net.IPAddr{IP: net.ParseIP(target)}); err != nil {
-			log.Error(err)
-			return 0.0, false, nil
-		}
-	}
-
-	// Is this the right place?
-	start := time.Now()
-
-	rb := make([]byte, 1500)
-	n, peer, err := c.ReadFrom(rb)
-	if err != nil {
-		log.Debugf("Ping timeout on %v", peer)
-		return 0.0, false, nil
-	}
-
-	// Is this the right place?
-	elapsed := time.Since(start)
-
-	rm, err := icmp.ParseMessage(replyproto, rb[:n])
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// This block performs triage on incoming requests
-	//
-	// TODO(mierdin): This has been a source of a lot of confusion. You will notice that
-	// both requests and responses are in both the IPv4 and IPv6 blocks. This is because
-	// rm.Type doesn't always seem to equal what's in the response packet (I checked with
-	// (tcpdump)
-	//
-	// For instance:
-	//
-	// 	  case ipv6.ICMPTypeEchoReply:
-	// 	  // This is an expected response type for ICMPv6 requests, and the packet contains
-	//    // this code, but...
-	//    case ipv6.ICMPTypeEchoRequest:
-	// 	  // ...this is what we end up seeing instead, which is of course not the right code
-	//    // for a response.
-	//
-	// Need to figure out what's causing this - it could be a bug in the library.
-	if ip.To4() != nil {
-
-		switch rm.Type {
-		case ipv4.ICMPTypeEcho:
-		case ipv4.ICMPTypeEchoReply:
-			// This is an expected response type for ICMPv4 requests
-		default:
-			log.Printf("ERROR. Got %+v; want IPV4 echo reply", rm)
-			return 0, false, errors.New("Received something other than an IPV4 echo reply")
-		}
-	} else {
-		switch rm.Type {
-		case ipv6.ICMPTypeEchoReply:
-			// This is an expected response type for ICMPv6 requests, but....
-		case ipv6.ICMPTypeEchoRequest:
-			// ...this is what we end up seeing instead. TODO(mierdin): Dig into
-			// this a bit more and see if this is a bug in the library
-		default:
-			log.Printf("ERROR. Got %+v; want IPV6 echo reply", rm)
-			return 0, false, errors.New("Received something other than an IPV6 echo reply")
-		}
-	}
-
-	// Return the latency in milliseconds, and acknowledge that a reply was received
-	return float32(elapsed.Seconds() * 1e3), true, nil
-
+	return wm.Marshal(nil)
 }
@@ -0,0 +1,46 @@
+package ping
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRunTimestamping(t *testing.T) {
+	args := map[string]interface{}{"count": 2, "icmpTimeout": 1, "interval_ms": 0}
+	results, err := PingTestlet{}.RunDetailed("127.0.0.1", args, 0)
+	if ErrorCode(err) == CodePermission {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reported without the records arg, both in the results and as metrics
+	rx, tx := results.Metrics["kernel_rx_timestamps"], results.Metrics["kernel_tx_timestamps"]
+	switch results.Timestamping {
+	case timestampsKernel:
+		if rx != 1 || tx != 1 {
+			t.Errorf("kernel timestamping reported as rx %v, tx %v", rx, tx)
+		}
+	case timestampsKernelRX:
+		if rx != 1 || tx != 0 {
+			t.Errorf("kernel-rx timestamping reported as rx %v, tx %v", rx, tx)
+		}
+	case timestampsUser:
+		if _, ok := results.Metrics["kernel_rx_timestamps"]; !ok || rx != 0 || tx != 0 {
+			t.Errorf("userspace timestamping reported as rx %v, tx %v", rx, tx)
+		}
+	default:
+		t.Fatalf("Timestamping = %q", results.Timestamping)
+	}
+
+	b, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	json.Unmarshal(b, &out)
+	if out["timestamping"] != results.Timestamping {
+		t.Errorf("timestamping %v in %s, want %q", out["timestamping"], b, results.Timestamping)
+	}
+}
@@ -0,0 +1,384 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Extended echo message types (RFC 8335). These postdate the vendored icmp package,
+// so messages are built and parsed here.
+const (
+	icmpTypeExtendedEchoRequest   = ipv4.ICMPType(42)
+	icmpTypeExtendedEchoReply     = ipv4.ICMPType(43)
+	icmpv6TypeExtendedEchoRequest = ipv6.ICMPType(160)
+	icmpv6TypeExtendedEchoReply   = ipv6.ICMPType(161)
+)
+
+// Interface Identification Object (RFC 8335, section 2.1)
+const (
+	probeExtensionVersion = 2
+	probeClassNum         = 3
+
+	probeByName    = 1
+	probeByIndex   = 2
+	probeByAddress = 3
+)
+
+// Extended echo reply codes (RFC 8335, section 3)
+var probeReplyCodes = map[int]string{
+	0: "no error",
+	1: "malformed query",
+	2: "no such interface",
+	3: "no such table entry",
+	4: "multiple interfaces satisfy query",
+}
+
+// Neighbor states reported for a proxied interface (RFC 8335, section 3)
+var probeStates = map[int]string{
+	1: "incomplete",
+	2: "reachable",
+	3: "stale",
+	4: "delay",
+	5: "probe",
+	6: "failed",
+}
+
+// InterfaceStatus is what a node reported about the interface queried with an extended echo request
+type InterfaceStatus struct {
+	// Error describes why the query couldn't be answered, either from the reply code, or
+	// from an ICMP error returned in place of a reply. Empty when the query succeeded.
+	Error string `json:"error,omitempty"`
+
+	Active bool `json:"active"`
+	IPv4   bool `json:"ipv4"`
+	IPv6   bool `json:"ipv6"`
+
+	// State is the neighbor table state, only reported for interfaces not local to the node (L unset)
+	State string `json:"state,omitempty"`
+
+	// Responder is the address the reply (or ICMP error) came from
+	Responder string `json:"responder,omitempty"`
+}
+
+// PingProbe sends an RFC 8335 extended echo request to target, asking about the interface
+// identified by iface: an interface name, an ifIndex, or an address. local sets the L bit,
+// meaning the interface belongs to the target itself rather than being one of its neighbors.
+// returns:
+// float32 - response time in milliseconds
+// *InterfaceStatus - the interface state, or the error returned instead (nil on timeout)
+// error - nil if everything went well
+func PingProbe(target string, seq, icmpTimeout int, iface string, local bool) (float32, *InterfaceStatus, error) {
+	return pingProbe(context.Background(), target, seq, icmpTimeout, iface, local)
+}
+
+// pingProbe is PingProbe, giving up on the reply once ctx has been cancelled for interruptGrace
+func pingProbe(ctx context.Context, target string, seq, icmpTimeout int, iface string, local bool) (float32, *InterfaceStatus, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return 0.0, nil, err
+	}
+
+	c, err := listen(t)
+	if err != nil {
+		return 0.0, nil, err
+	}
+	defer c.Close()
+
+	wb, err := extendedEchoRequest(t, seq, iface, local)
+	if err != nil {
+		return 0.0, nil, err
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Duration(icmpTimeout) * time.Second))
+	defer cutShort(ctx, c)()
+
+	if _, err := c.WriteTo(wb, t.addr(c.network)); err != nil {
+		return 0.0, nil, err
+	}
+	start := time.Now()
+
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(rb)
+		if err != nil {
+			log.Debugf("Extended echo timeout on %v", t)
+			return 0.0, nil, nil
+		}
+		elapsed := time.Since(start)
+
+		rm, err := icmp.ParseMessage(c.proto, rb[:n])
+		if err != nil {
+			continue
+		}
+
+		var status *InterfaceStatus
+		switch rm.Type {
+		case icmpTypeExtendedEchoReply, icmpv6TypeExtendedEchoReply:
+			if !peerIP(peer).Equal(t.IP) {
+				continue
+			}
+			body, ok := rm.Body.(*icmp.DefaultMessageBody)
+			if !ok || len(body.Data) < 4 || !c.ownsProbe(body.Data, seq) {
+				continue
+			}
+			status = parseExtendedEchoReply(rm.Code, body.Data)
+			status.Responder = peerAddress(peer)
+
+		default:
+			// Routers that don't understand (or won't answer) the query may send an ICMP error
+			typ, quoted := quotedMessage(rm)
+			if quoted == nil || (typ != icmpTypeExtendedEchoRequest && typ != icmpv6TypeExtendedEchoRequest) {
+				continue
+			}
+			if len(quoted) < 8 || !c.ownsProbe(quoted[4:], seq) {
+				continue
+			}
+			status = &InterfaceStatus{Error: fmt.Sprintf("%v (code %d)", rm.Type, rm.Code), Responder: peerAddress(peer)}
+		}
+
+		return float32(elapsed.Seconds() * 1e3), status, nil
+	}
+}
+
+// ownsProbe checks the identifier and sequence number at the start of an extended echo body.
+// The kernel picks the identifier for datagram sockets, so only the sequence number can be checked there.
+func (c *conn) ownsProbe(b []byte, seq int) bool {
+	if int(b[2]) != seq&0xff {
+		return false
+	}
+	return !c.raw() || int(binary.BigEndian.Uint16(b[:2])) == os.Getpid()&0xffff
+}
+
+// extendedEchoRequest builds an extended echo request for t, carrying an Interface
+// Identification Object that names iface
+func extendedEchoRequest(t Target, seq int, iface string, local bool) ([]byte, error) {
+
+	object, err := interfaceIdentification(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identifier, sequence number, and the L bit
+	data := make([]byte, 4, 4+4+len(object))
+	binary.BigEndian.PutUint16(data[:2], uint16(os.Getpid()&0xffff))
+	data[2] = byte(seq)
+	if local {
+		data[3] = 0x01
+	}
+
+	// Extension structure header, whose checksum covers the object
+	ext := append([]byte{probeExtensionVersion << 4, 0, 0, 0}, object...)
+	binary.BigEndian.PutUint16(ext[2:4], checksum(ext))
+	data = append(data, ext...)
+
+	wm := icmp.Message{Code: 0, Body: &icmp.DefaultMessageBody{Data: data}}
+	if t.IsIPv4() {
+		wm.Type = icmpTypeExtendedEchoRequest
+	} else {
+		wm.Type = icmpv6TypeExtendedEchoRequest
+	}
+	return wm.Marshal(nil)
+}
+
+// interfaceIdentification encodes an Interface Identification Object for iface, which
+// is taken as an ifIndex if numeric, an address if it parses as one, and a name otherwise
+func interfaceIdentification(iface string) ([]byte, error) {
+	if iface == "" {
+		return nil, argError(errors.New("an interface to probe (name, ifIndex or address) is required"))
+	}
+
+	var ctype int
+	var payload []byte
+
+	if index, err := strconv.ParseUint(iface, 10, 32); err == nil {
+		ctype = probeByIndex
+		payload = make([]byte, 4)
+		binary.BigEndian.PutUint32(payload, uint32(index))
+
+	} else if ip := net.ParseIP(iface); ip != nil {
+		// Address Family Identifier, address length, reserved
+		ctype = probeByAddress
+		afi, addr := 2, ip.To16()
+		if ip4 := ip.To4(); ip4 != nil {
+			afi, addr = 1, ip4
+		}
+		payload = []byte{0, byte(afi), byte(len(addr)), 0}
+		payload = append(payload, addr...)
+
+	} else {
+		// Names are padded with NULs to a 32-bit boundary
+		ctype = probeByName
+		payload = make([]byte, (len(iface)+3)/4*4)
+		copy(payload, iface)
+	}
+
+	object := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint16(object[:2], uint16(4+len(payload)))
+	object[2] = probeClassNum
+	object[3] = byte(ctype)
+	return append(object, payload...), nil
+}
+
+// parseExtendedEchoReply decodes the status carried by an extended echo reply body
+func parseExtendedEchoReply(code int, b []byte) *InterfaceStatus {
+	if code != 0 {
+		msg, ok := probeReplyCodes[code]
+		if !ok {
+			msg = fmt.Sprintf("unknown code %d", code)
+		}
+		return &InterfaceStatus{Error: msg}
+	}
+
+	// State (3 bits), reserved (2 bits), then the A, 4 and 6 flags
+	flags := b[3]
+	return &InterfaceStatus{
+		Active: flags&0x04 != 0,
+		IPv4:   flags&0x02 != 0,
+		IPv6:   flags&0x01 != 0,
+		State:  probeStates[int(flags>>5)],
+	}
+}
+
+// quotedMessage returns the type and raw bytes of our own ICMP message as quoted back inside
+// an ICMP error (destination unreachable, time exceeded, parameter problem), or nil if m isn't
+// an error or the quoted datagram is unusable
+func quotedMessage(m *icmp.Message) (icmp.Type, []byte) {
+	var data []byte
+	switch body := m.Body.(type) {
+	case *icmp.DstUnreach:
+		data = body.Data
+	case *icmp.TimeExceeded:
+		data = body.Data
+	case *icmp.ParamProb:
+		data = body.Data
+	case *icmp.PacketTooBig:
+		data = body.Data
+	default:
+		return nil, nil
+	}
+
+	// Skip past the quoted IP header
+	if _, ok := m.Type.(ipv4.ICMPType); ok {
+		if len(data) < ipv4.HeaderLen {
+			return nil, nil
+		}
+		hlen := int(data[0]&0x0f) << 2
+		if data[9] != protocolICMP || len(data) < hlen+4 {
+			return nil, nil
+		}
+		return ipv4.ICMPType(data[hlen]), data[hlen:]
+	}
+
+	if len(data) < ipv6.HeaderLen+4 || data[6] != protocolIPv6ICMP {
+		return nil, nil
+	}
+	return ipv6.ICMPType(data[ipv6.HeaderLen]), data[ipv6.HeaderLen:]
+}
+
+// checksum is the Internet checksum (RFC 1071) of b
+func checksum(b []byte) uint16 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s>>16 != 0 {
+		s = s>>16 + s&0xffff
+	}
+	return ^uint16(s)
+}
+
+// probeRun carries out count extended echo probes towards target, one at a time as sched allows,
+// and reports the interface status from the last response
+func probeRun(ctx context.Context, target string, count, icmpTimeout int, iface string, local bool, sched *schedule, emit func(Event)) (map[string]float32, *InterfaceStatus, error) {
+
+	var latencies []float32
+	var replies, probeErrors int
+	var last *InterfaceStatus
+
+	i := 0
+	for ; i < count && ctx.Err() == nil; i++ {
+		next := time.Now().Add(sched.next())
+		emit(Event{Type: EventSent, Seq: i, Time: time.Now()})
+		latency, status, err := pingProbe(ctx, target, i, icmpTimeout, iface, local)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if status == nil {
+			log.Info("Request timed out.")
+			emit(Event{Type: EventTimeout, Seq: i, Time: time.Now()})
+		} else {
+			last = status
+			latencies = append(latencies, latency)
+			replies++
+			if status.Error != "" {
+				log.Infof("Probe of '%s' on %s failed after %f ms: %s", iface, target, latency, status.Error)
+				probeErrors++
+				emit(Event{Type: EventError, Seq: i, Time: time.Now(), LatencyMs: latency, Responder: status.Responder, Error: status.Error})
+			} else {
+				log.Infof("Probe of '%s' on %s answered after %f ms (active: %t)", iface, target, latency, status.Active)
+				emit(Event{Type: EventReply, Seq: i, Time: time.Now(), LatencyMs: latency, Responder: status.Responder})
+			}
+		}
+
+		if i < count-1 {
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	// An interrupted run is summarized over the probes actually sent
+	if i < count {
+		log.Warnf("Interrupted after %d of %d probes", i, count)
+		count = i
+		if count == 0 {
+			return map[string]float32{}, nil, nil
+		}
+	}
+
+	var latencyTotal, avgLatency float32
+	for _, value := range latencies {
+		latencyTotal += value
+	}
+	if replies > 0 {
+		avgLatency = latencyTotal / float32(replies)
+	}
+
+	metrics := map[string]float32{
+		"avg_latency_ms": avgLatency,
+		"packet_loss":    (float32(count) - float32(replies)) / float32(count),
+		"probe_errors":   float32(probeErrors),
+	}
+	if last != nil && last.Error == "" {
+		metrics["interface_active"] = boolMetric(last.Active)
+		metrics["interface_ipv4"] = boolMetric(last.IPv4)
+		metrics["interface_ipv6"] = boolMetric(last.IPv6)
+	}
+
+	return metrics, last, nil
+}
+
+// boolMetric expresses a flag as a metric value
+func boolMetric(b bool) float32 {
+	if b {
+		return 1
+	}
+	return 0
+}
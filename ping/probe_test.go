@@ -0,0 +1,59 @@
+package ping
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInterfaceIdentification(t *testing.T) {
+	for _, tc := range []struct {
+		iface string
+		want  []byte
+	}{
+		{"7", []byte{0, 8, probeClassNum, probeByIndex, 0, 0, 0, 7}},
+		{"4294967295", []byte{0, 8, probeClassNum, probeByIndex, 0xff, 0xff, 0xff, 0xff}},
+		{"192.0.2.1", []byte{0, 12, probeClassNum, probeByAddress, 0, 1, 4, 0, 192, 0, 2, 1}},
+		{"2001:db8::1", append([]byte{0, 24, probeClassNum, probeByAddress, 0, 2, 16, 0},
+			0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1)},
+		{"eth0", []byte{0, 8, probeClassNum, probeByName, 'e', 't', 'h', '0'}},
+		{"lo", []byte{0, 8, probeClassNum, probeByName, 'l', 'o', 0, 0}},
+		{"eth0.10", []byte{0, 12, probeClassNum, probeByName, 'e', 't', 'h', '0', '.', '1', '0', 0}},
+		// Too large for an ifIndex, so it can only be a name
+		{"4294967296", []byte{0, 16, probeClassNum, probeByName, '4', '2', '9', '4', '9', '6', '7', '2', '9', '6', 0, 0}},
+	} {
+		got, err := interfaceIdentification(tc.iface)
+		if err != nil {
+			t.Errorf("interfaceIdentification(%q): %v", tc.iface, err)
+		} else if !bytes.Equal(got, tc.want) {
+			t.Errorf("interfaceIdentification(%q) = %v, want %v", tc.iface, got, tc.want)
+		}
+	}
+
+	if _, err := interfaceIdentification(""); ErrorCode(err) != CodeInvalidArgs {
+		t.Errorf("interfaceIdentification(\"\") returned %v, want an invalid args error", err)
+	}
+}
+
+func TestParseExtendedEchoReply(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code int
+		body []byte
+		want InterfaceStatus
+	}{
+		{"active dual-stack", 0, []byte{0, 1, 0, 0x07}, InterfaceStatus{Active: true, IPv4: true, IPv6: true}},
+		{"active IPv6 only", 0, []byte{0, 1, 0, 0x05}, InterfaceStatus{Active: true, IPv6: true}},
+		{"inactive", 0, []byte{0, 1, 0, 0}, InterfaceStatus{}},
+		{"proxied and reachable", 0, []byte{0, 1, 0, 2<<5 | 0x06}, InterfaceStatus{Active: true, IPv4: true, State: "reachable"}},
+		{"proxied and failed", 0, []byte{0, 1, 0, 6 << 5}, InterfaceStatus{State: "failed"}},
+		{"no such interface", 2, []byte{0, 1, 0, 0}, InterfaceStatus{Error: "no such interface"}},
+		{"unknown code", 9, []byte{0, 1, 0, 0}, InterfaceStatus{Error: "unknown code 9"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := parseExtendedEchoReply(tc.code, tc.body); !reflect.DeepEqual(*got, tc.want) {
+				t.Errorf("got %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package ping
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rttBuckets are the upper bounds of the round trip time histogram, in seconds
+var rttBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// TargetResults are the results of a run against a single target. Err is why the run failed, if
+// it did, in which case there are no Results.
+type TargetResults struct {
+	Target  string
+	Results *Results
+	Err     error
+}
+
+// WritePrometheus renders the results of one or more runs in the Prometheus text exposition
+// format, labelled by target. Every metric becomes a gauge named after it with a "ping_" prefix
+// (so keeping the units in its name); if the results include probe records, the round trip
+// times of the replies also make up a histogram, ping_rtt_seconds. Every run, failed or not, gets
+// ping_probe_success, as with the blackbox exporter. With openMetrics set, the output is in the
+// OpenMetrics text format instead.
+func WritePrometheus(w io.Writer, runs []TargetResults, openMetrics bool) error {
+
+	bw := bufio.NewWriter(w)
+
+	// Each metric's samples have to be grouped together, under a single HELP and TYPE
+	names := map[string]bool{}
+	for _, run := range runs {
+		if run.Results == nil {
+			continue
+		}
+		for name := range run.Results.Metrics {
+			names[name] = true
+		}
+	}
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		help := name
+		if def, ok := metricDef(name); ok {
+			help = def.Help
+		}
+		fmt.Fprintf(bw, "# HELP ping_%s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(bw, "# TYPE ping_%s gauge\n", name)
+		for _, run := range runs {
+			if run.Results == nil {
+				continue
+			}
+			if v, ok := run.Results.Metrics[name]; ok {
+				fmt.Fprintf(bw, "ping_%s{target=\"%s\"} %v\n", name, escapeLabel(run.Target), v)
+			}
+		}
+	}
+
+	bw.WriteString("# HELP ping_probe_success Whether the run completed with any probe answered (in sweep mode, any host)\n")
+	bw.WriteString("# TYPE ping_probe_success gauge\n")
+	for _, run := range runs {
+		success := 0
+		if run.succeeded() {
+			success = 1
+		}
+		fmt.Fprintf(bw, "ping_probe_success{target=\"%s\"} %d\n", escapeLabel(run.Target), success)
+	}
+
+	// Only present when a run was cut short, as its other metrics then cover part of it
+	interrupted := false
+	for _, run := range runs {
+		interrupted = interrupted || run.Results != nil && run.Results.Interrupted
+	}
+	if interrupted {
+		bw.WriteString("# HELP ping_interrupted Whether the run was interrupted before sending every probe\n")
+		bw.WriteString("# TYPE ping_interrupted gauge\n")
+		for _, run := range runs {
+			if run.Results != nil && run.Results.Interrupted {
+				fmt.Fprintf(bw, "ping_interrupted{target=\"%s\"} 1\n", escapeLabel(run.Target))
+			}
+		}
+	}
+
+	histogram := false
+	for _, run := range runs {
+		histogram = histogram || run.Results != nil && run.Results.Probes != nil
+	}
+	if histogram {
+		bw.WriteString("# HELP ping_rtt_seconds Round trip times of the probes that were answered\n")
+		bw.WriteString("# TYPE ping_rtt_seconds histogram\n")
+		if openMetrics {
+			bw.WriteString("# UNIT ping_rtt_seconds seconds\n")
+		}
+		for _, run := range runs {
+			if run.Results != nil && run.Results.Probes != nil {
+				writeRTTHistogram(bw, run.Target, run.Results.Probes, openMetrics)
+			}
+		}
+	}
+
+	if openMetrics {
+		bw.WriteString("# EOF\n")
+	}
+	return bw.Flush()
+}
+
+// succeeded reports whether the run completed with any probe answered, or in sweep mode any host
+// found alive
+func (r TargetResults) succeeded() bool {
+	if r.Err != nil || r.Results == nil {
+		return false
+	}
+	if loss, ok := r.Results.Metrics["packet_loss"]; ok {
+		return loss < 1
+	}
+	return r.Results.Metrics["hosts_alive"] > 0
+}
+
+// writeRTTHistogram writes the samples of the ping_rtt_seconds histogram for one target
+func writeRTTHistogram(w io.Writer, target string, probes []ProbeRecord, openMetrics bool) {
+
+	counts := make([]int, len(rttBuckets))
+	var count int
+	var sum float64
+	for _, p := range probes {
+		if p.Outcome != outcomeReply {
+			continue
+		}
+		rtt := float64(p.LatencyMs) / 1e3
+		for i, le := range rttBuckets {
+			if rtt <= le {
+				counts[i]++
+			}
+		}
+		count++
+		sum += rtt
+	}
+
+	label := escapeLabel(target)
+	for i, le := range rttBuckets {
+		fmt.Fprintf(w, "ping_rtt_seconds_bucket{target=\"%s\",le=\"%s\"} %d\n", label, formatBound(le, openMetrics), counts[i])
+	}
+	fmt.Fprintf(w, "ping_rtt_seconds_bucket{target=\"%s\",le=\"+Inf\"} %d\n", label, count)
+	fmt.Fprintf(w, "ping_rtt_seconds_sum{target=\"%s\"} %v\n", label, sum)
+	fmt.Fprintf(w, "ping_rtt_seconds_count{target=\"%s\"} %d\n", label, count)
+}
+
+// formatBound formats a bucket's upper bound. OpenMetrics requires canonical floats, which always
+// have a decimal point or exponent (1.0 rather than 1).
+func formatBound(le float64, openMetrics bool) string {
+	s := strconv.FormatFloat(le, 'g', -1, 64)
+	if openMetrics && !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// escapeLabel escapes a label value for the exposition format
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// escapeHelp escapes a metric's help text for the exposition format
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
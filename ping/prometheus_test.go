@@ -0,0 +1,153 @@
+package ping
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// prometheusRuns are a run with probe records, an interrupted one against a target needing
+// escaping, and one that failed
+var prometheusRuns = []TargetResults{
+	{
+		Target: "192.0.2.1",
+		Results: &Results{
+			Metrics: map[string]float32{"packet_loss": 0.25, "avg_latency_ms": 1.5},
+			Probes: []ProbeRecord{
+				{Seq: 0, Outcome: outcomeReply, LatencyMs: 0.25},
+				{Seq: 1, Outcome: outcomeReply, LatencyMs: 1000},
+				{Seq: 2, Outcome: outcomeReply, LatencyMs: 7000},
+				{Seq: 3, Outcome: outcomeTimeout},
+			},
+		},
+	},
+	{
+		Target: "a\"b\\c\nd",
+		Results: &Results{
+			Metrics:     map[string]float32{"packet_loss": 1, "custom": 2},
+			Interrupted: true,
+		},
+	},
+	{
+		Target: "192.0.2.9",
+		Err:    errors.New("'192.0.2.9' has no ipv6 address"),
+	},
+}
+
+const prometheusMetrics = `# HELP ping_avg_latency_ms Average round trip time (lost probes count as 0 for icmp, tcp, udp and twamp)
+# TYPE ping_avg_latency_ms gauge
+ping_avg_latency_ms{target="192.0.2.1"} 1.5
+# HELP ping_custom custom
+# TYPE ping_custom gauge
+ping_custom{target="a\"b\\c\nd"} 2
+# HELP ping_packet_loss Fraction of probes that weren't answered
+# TYPE ping_packet_loss gauge
+ping_packet_loss{target="192.0.2.1"} 0.25
+ping_packet_loss{target="a\"b\\c\nd"} 1
+# HELP ping_probe_success Whether the run completed with any probe answered (in sweep mode, any host)
+# TYPE ping_probe_success gauge
+ping_probe_success{target="192.0.2.1"} 1
+ping_probe_success{target="a\"b\\c\nd"} 0
+ping_probe_success{target="192.0.2.9"} 0
+# HELP ping_interrupted Whether the run was interrupted before sending every probe
+# TYPE ping_interrupted gauge
+ping_interrupted{target="a\"b\\c\nd"} 1
+# HELP ping_rtt_seconds Round trip times of the probes that were answered
+# TYPE ping_rtt_seconds histogram
+`
+
+const prometheusBuckets = `ping_rtt_seconds_bucket{target="192.0.2.1",le="0.0001"} 0
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.00025"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.0005"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.001"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.0025"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.005"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.01"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.025"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.05"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.1"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.25"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="0.5"} 1
+ping_rtt_seconds_bucket{target="192.0.2.1",le="%s"} 2
+ping_rtt_seconds_bucket{target="192.0.2.1",le="2.5"} 2
+ping_rtt_seconds_bucket{target="192.0.2.1",le="%s"} 2
+ping_rtt_seconds_bucket{target="192.0.2.1",le="+Inf"} 3
+ping_rtt_seconds_sum{target="192.0.2.1"} 8.00025
+ping_rtt_seconds_count{target="192.0.2.1"} 3
+`
+
+func TestWritePrometheus(t *testing.T) {
+	var b bytes.Buffer
+	if err := WritePrometheus(&b, prometheusRuns, false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := prometheusMetrics + fmt.Sprintf(prometheusBuckets, "1", "5")
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	var b bytes.Buffer
+	if err := WritePrometheus(&b, prometheusRuns, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bounds are canonical floats, and the output is terminated
+	want := prometheusMetrics + "# UNIT ping_rtt_seconds seconds\n" + fmt.Sprintf(prometheusBuckets, "1.0", "5.0") + "# EOF\n"
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWritePrometheusSweep(t *testing.T) {
+	runs := []TargetResults{{Target: "192.0.2.0/30", Results: &Results{Metrics: map[string]float32{"hosts_swept": 2, "hosts_alive": 1}}}}
+
+	want := `# HELP ping_hosts_alive Hosts that replied
+# TYPE ping_hosts_alive gauge
+ping_hosts_alive{target="192.0.2.0/30"} 1
+# HELP ping_hosts_swept Hosts swept
+# TYPE ping_hosts_swept gauge
+ping_hosts_swept{target="192.0.2.0/30"} 2
+# HELP ping_probe_success Whether the run completed with any probe answered (in sweep mode, any host)
+# TYPE ping_probe_success gauge
+ping_probe_success{target="192.0.2.0/30"} 1
+`
+	for _, tc := range []struct {
+		openMetrics bool
+		want        string
+	}{
+		{false, want},
+		{true, want + "# EOF\n"},
+	} {
+		var b bytes.Buffer
+		if err := WritePrometheus(&b, runs, tc.openMetrics); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != tc.want {
+			t.Errorf("openMetrics %t: got:\n%s\nwant:\n%s", tc.openMetrics, got, tc.want)
+		}
+	}
+}
+
+func TestFormatBound(t *testing.T) {
+	for _, tc := range []struct {
+		le                      float64
+		prometheus, openMetrics string
+	}{
+		{0.0001, "0.0001", "0.0001"},
+		{0.25, "0.25", "0.25"},
+		{1, "1", "1.0"},
+		{10, "10", "10.0"},
+		{1e21, "1e+21", "1e+21"},
+	} {
+		if got := formatBound(tc.le, false); got != tc.prometheus {
+			t.Errorf("formatBound(%v, false) = %q, want %q", tc.le, got, tc.prometheus)
+		}
+		if got := formatBound(tc.le, true); got != tc.openMetrics {
+			t.Errorf("formatBound(%v, true) = %q, want %q", tc.le, got, tc.openMetrics)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package ping
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// Probe outcomes, as reported in ProbeRecords
+const (
+	outcomeReply   = "reply"
+	outcomeTimeout = "timeout"
+	outcomeLate    = "late" // a reply arrived, but after the timeout
+	outcomeError   = "error"
+)
+
+// ProbeRecord is what happened to a single probe, for the detailed results requested with the
+// records arg. Responder, TTL and Size are only known for icmp probes.
+type ProbeRecord struct {
+	Seq       int       `json:"seq"`
+	Sent      time.Time `json:"sent"`
+	Outcome   string    `json:"outcome"`
+	LatencyMs float32   `json:"latency_ms,omitempty"`
+	Responder string    `json:"responder,omitempty"`
+	TTL       int       `json:"ttl,omitempty"`
+	Size      int       `json:"size,omitempty"`
+
+	// Error describes what went wrong when Outcome is "error": the type of ICMP error received
+	// (such as "destination unreachable"), or the failure to send the probe
+	Error string `json:"error,omitempty"`
+}
+
+// probeError is an ICMP error received in response to a probe
+type probeError struct {
+	Type icmp.Type
+	Code int
+	From string
+}
+
+func (e *probeError) Error() string {
+	return fmt.Sprintf("%s (code %d) from %s", e.Type, e.Code, e.From)
+}
+
+// newRecord describes the outcome of a probe sent at sent, as returned by one of the
+// Ping functions
+func newRecord(seq int, sent time.Time, latency float32, replied bool, err error) ProbeRecord {
+	r := ProbeRecord{Seq: seq, Sent: sent, Outcome: outcomeTimeout}
+	switch {
+	case replied:
+		r.Outcome = outcomeReply
+		r.LatencyMs = latency
+	case err != nil:
+		r.Outcome = outcomeError
+		r.Error = err.Error()
+		if pe, ok := err.(*probeError); ok {
+			r.Error = fmt.Sprint(pe.Type)
+			r.Responder = pe.From
+		}
+	}
+	return r
+}
@@ -0,0 +1,233 @@
+package ping
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// reorderHold is the longest a reordered datagram is held back waiting for another to overtake it
+const reorderHold = time.Second
+
+// Impairments are the network conditions a Reflector emulates on the datagrams it returns
+type Impairments struct {
+	Delay  time.Duration // added to every datagram
+	Jitter time.Duration // random variation of Delay, up to this much either way
+
+	// Probabilities between 0 and 1
+	Loss      float64 // datagram is dropped
+	Duplicate float64 // datagram is reflected twice
+	Reorder   float64 // datagram is held back and sent after the next one
+
+	// Seed makes the random choices reproducible. 0 uses the current time.
+	Seed int64
+}
+
+// Reflector is a UDP echo server, returning every datagram to its sender (subject to any
+// Impairments). It gives PingUDP a known-good responder to run against, or in TWAMP mode
+// acts as a stateless TWAMP-Light reflector for PingTWAMP.
+type Reflector struct {
+	Impairments
+
+	conn *net.UDPConn
+
+	// twamp reflects datagrams as TWAMP-Light test packets rather than echoing them verbatim
+	twamp bool
+
+	mu   sync.Mutex
+	rand *rand.Rand
+
+	// A datagram held back for reordering
+	held    *outgoing
+	heldSeq int
+
+	received, reflected int
+}
+
+// NewReflector opens a UDP echo reflector listening on addr (e.g. ":7")
+func NewReflector(addr string, impairments Impairments) (*Reflector, error) {
+	return newReflector(addr, impairments, false)
+}
+
+// NewTWAMPReflector opens a TWAMP-Light reflector listening on addr (e.g. ":862")
+func NewTWAMPReflector(addr string, impairments Impairments) (*Reflector, error) {
+	return newReflector(addr, impairments, true)
+}
+
+func newReflector(addr string, impairments Impairments, twamp bool) (*Reflector, error) {
+
+	if impairments.Jitter > impairments.Delay {
+		return nil, argError(errors.New("jitter can't be larger than delay"))
+	}
+	for _, p := range []float64{impairments.Loss, impairments.Duplicate, impairments.Reorder} {
+		if p < 0 || p > 1 {
+			return nil, argError(errors.New("loss, duplicate and reorder must be between 0 and 1"))
+		}
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, argError(err)
+	}
+	c, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// TWAMP reflectors report the TTL each test packet arrived with
+	if twamp {
+		if err := setRecvHops(c); err != nil {
+			log.Warnf("Unable to read the TTL of received packets: %v", err)
+		}
+	}
+
+	seed := impairments.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &Reflector{
+		Impairments: impairments,
+		conn:        c,
+		twamp:       twamp,
+		rand:        rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// Addr returns the address the reflector is listening on
+func (r *Reflector) Addr() net.Addr {
+	return r.conn.LocalAddr()
+}
+
+// Close stops the reflector
+func (r *Reflector) Close() error {
+	return r.conn.Close()
+}
+
+// Stats returns how many datagrams have been received and sent back so far
+func (r *Reflector) Stats() (received, reflected int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.received, r.reflected
+}
+
+// Serve reflects datagrams until the reflector is closed
+func (r *Reflector) Serve() error {
+
+	if r.twamp {
+		log.Infof("Reflecting TWAMP-Light test packets on %s", r.Addr())
+	} else {
+		log.Infof("Reflecting UDP datagrams on %s", r.Addr())
+	}
+
+	b := make([]byte, 65536)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, peer, err := r.conn.ReadMsgUDP(b, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		received := time.Now()
+
+		datagram := make([]byte, n)
+		copy(datagram, b[:n])
+
+		// TWAMP replies are stamped before any impairments are applied, so that emulated
+		// delay shows up as delay on the path back to the sender
+		if r.twamp {
+			if datagram, err = twampReflect(datagram, received, parseHops(oob[:oobn]), time.Now()); err != nil {
+				log.Debugf("Not reflecting datagram from %s: %v", peer, err)
+				continue
+			}
+		}
+
+		r.reflect(outgoing{datagram, peer})
+	}
+}
+
+// reflect decides what happens to a single datagram, and schedules it to be sent back
+func (r *Reflector) reflect(o outgoing) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.received++
+
+	if r.rand.Float64() < r.Loss {
+		log.Debugf("Dropping datagram from %s", o.to)
+		return
+	}
+
+	out := []outgoing{o}
+	if r.rand.Float64() < r.Duplicate {
+		out = append(out, o)
+	}
+
+	// Anything held back goes out right after this datagram, so this one overtakes it
+	if r.held != nil {
+		out = append(out, *r.held)
+		r.held = nil
+		r.sendAfter(r.delay(), out...)
+		return
+	}
+
+	if r.rand.Float64() < r.Reorder {
+		r.held = &o
+		r.heldSeq++
+		seq := r.heldSeq
+
+		// Don't hold on forever if nothing else arrives
+		time.AfterFunc(reorderHold, func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.held != nil && r.heldSeq == seq {
+				r.sendAfter(0, *r.held)
+				r.held = nil
+			}
+		})
+		return
+	}
+
+	r.sendAfter(r.delay(), out...)
+}
+
+// delay picks how long to hold the next datagram, applying jitter around Delay. Must be
+// called with r.mu held, as rand.Rand isn't safe for concurrent use.
+func (r *Reflector) delay() time.Duration {
+	d := r.Delay
+	if r.Jitter > 0 {
+		d += time.Duration(r.rand.Int63n(int64(2*r.Jitter)+1)) - r.Jitter
+	}
+	return d
+}
+
+// outgoing is a datagram waiting to be reflected
+type outgoing struct {
+	datagram []byte
+	to       *net.UDPAddr
+}
+
+// sendAfter sends datagrams, in order, once d has elapsed. Must be called with r.mu held.
+func (r *Reflector) sendAfter(d time.Duration, out ...outgoing) {
+	r.reflected += len(out)
+
+	send := func() {
+		for _, o := range out {
+			if _, err := r.conn.WriteTo(o.datagram, o.to); err != nil && !errors.Is(err, net.ErrClosed) {
+				log.Debugf("Failed to reflect datagram to %s: %v", o.to, err)
+			}
+		}
+	}
+	if d <= 0 {
+		go send()
+		return
+	}
+	time.AfterFunc(d, send)
+}
@@ -0,0 +1,249 @@
+package ping
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startReflector runs a reflector on a free loopback port until the test ends, and returns it
+// along with its port
+func startReflector(t *testing.T, impairments Impairments, twamp bool) (*Reflector, int) {
+	t.Helper()
+
+	r, err := newReflector("127.0.0.1:0", impairments, twamp)
+	if err != nil {
+		t.Fatalf("newReflector: %v", err)
+	}
+	go r.Serve()
+	t.Cleanup(func() { r.Close() })
+
+	return r, r.Addr().(*net.UDPAddr).Port
+}
+
+// waitReceived waits for the reflector to have received n datagrams
+func waitReceived(t *testing.T, r *Reflector, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if received, _ := r.Stats(); received >= n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	received, _ := r.Stats()
+	t.Fatalf("reflector received %d datagrams, want %d", received, n)
+}
+
+func TestReflectorUDP(t *testing.T) {
+	r, port := startReflector(t, Impairments{}, false)
+
+	for seq := 0; seq < 3; seq++ {
+		latency, replied, err := PingUDP("127.0.0.1", seq, port, 1)
+		if err != nil {
+			t.Fatalf("PingUDP: %v", err)
+		}
+		if !replied {
+			t.Fatalf("seq %d wasn't reflected", seq)
+		}
+		if latency <= 0 {
+			t.Errorf("seq %d: latency %f, want > 0", seq, latency)
+		}
+	}
+
+	if received, reflected := r.Stats(); received != 3 || reflected != 3 {
+		t.Errorf("Stats() = %d, %d, want 3, 3", received, reflected)
+	}
+}
+
+func TestReflectorTWAMP(t *testing.T) {
+	_, port := startReflector(t, Impairments{}, true)
+
+	sample, err := PingTWAMP("127.0.0.1", 7, port, 1)
+	if err != nil {
+		t.Fatalf("PingTWAMP: %v", err)
+	}
+	if sample == nil {
+		t.Fatal("test packet wasn't reflected")
+	}
+
+	// Both ends share a clock, so neither direction can take longer than the round trip
+	if sample.RTTMs < 0 || sample.ForwardMs < 0 || sample.ReverseMs < 0 {
+		t.Errorf("negative delays in %+v", *sample)
+	}
+	if sample.ForwardMs > sample.RTTMs+1 || sample.ReverseMs > sample.RTTMs+1 {
+		t.Errorf("one-way delays exceed the round trip in %+v", *sample)
+	}
+}
+
+func TestReflectorDelay(t *testing.T) {
+	_, port := startReflector(t, Impairments{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond, Seed: 1}, false)
+
+	latency, replied, err := PingUDP("127.0.0.1", 0, port, 1)
+	if err != nil || !replied {
+		t.Fatalf("PingUDP = %f, %t, %v", latency, replied, err)
+	}
+	if latency < 40 {
+		t.Errorf("latency %f ms, want at least 40 ms", latency)
+	}
+}
+
+func TestReflectorTWAMPDelay(t *testing.T) {
+	_, port := startReflector(t, Impairments{Delay: 50 * time.Millisecond, Seed: 1}, true)
+
+	sample, err := PingTWAMP("127.0.0.1", 0, port, 1)
+	if err != nil || sample == nil {
+		t.Fatalf("PingTWAMP = %v, %v", sample, err)
+	}
+
+	// The delay is added after the reflector stamps the packet, so it's on the reverse path
+	if sample.ReverseMs < 50 || sample.ForwardMs >= 50 {
+		t.Errorf("forward %f ms, reverse %f ms, want the 50 ms delay on the reverse path", sample.ForwardMs, sample.ReverseMs)
+	}
+}
+
+func TestReflectorLoss(t *testing.T) {
+	r, port := startReflector(t, Impairments{Loss: 1}, false)
+
+	_, replied, err := PingUDP("127.0.0.1", 0, port, 1)
+	if err != nil {
+		t.Fatalf("PingUDP: %v", err)
+	}
+	if replied {
+		t.Error("datagram was reflected despite a loss of 1")
+	}
+	if received, reflected := r.Stats(); received != 1 || reflected != 0 {
+		t.Errorf("Stats() = %d, %d, want 1, 0", received, reflected)
+	}
+}
+
+func TestReflectorDuplicate(t *testing.T) {
+	_, port := startReflector(t, Impairments{Duplicate: 1}, false)
+
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("once")); err != nil {
+		t.Fatal(err)
+	}
+	got := readDatagrams(t, c, 2)
+	if got[0] != "once" || got[1] != "once" {
+		t.Errorf("got %q, want the datagram twice", got)
+	}
+}
+
+func TestReflectorReorder(t *testing.T) {
+	r, port := startReflector(t, Impairments{Reorder: 1}, false)
+
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The first datagram is held back until the second overtakes it
+	if _, err := c.Write([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	waitReceived(t, r, 1)
+	if _, err := c.Write([]byte("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readDatagrams(t, c, 2)
+	if got[0] != "second" || got[1] != "first" {
+		t.Errorf("got %q, want [second first]", got)
+	}
+}
+
+func TestReflectorSeed(t *testing.T) {
+	impairments := Impairments{Loss: 0.5, Duplicate: 0.3, Seed: 42}
+
+	var stats [2][2]int
+	for i := range stats {
+		r, port := startReflector(t, impairments, false)
+
+		c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j < 50; j++ {
+			c.Write([]byte{byte(j)})
+		}
+		c.Close()
+
+		waitReceived(t, r, 50)
+		stats[i][0], stats[i][1] = r.Stats()
+	}
+
+	if stats[0] != stats[1] {
+		t.Errorf("reflectors with the same seed reflected %v and %v", stats[0], stats[1])
+	}
+	if stats[0][1] == 0 || stats[0][1] == 50 {
+		t.Errorf("reflected %d of 50 datagrams with a loss of 0.5", stats[0][1])
+	}
+}
+
+func TestReflectorClose(t *testing.T) {
+	r, err := NewReflector("127.0.0.1:0", Impairments{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- r.Serve() }()
+
+	r.Close()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v after Close, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return after Close")
+	}
+}
+
+func TestNewReflectorInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		impairments Impairments
+	}{
+		{"jitter above delay", Impairments{Delay: time.Millisecond, Jitter: 2 * time.Millisecond}},
+		{"loss above 1", Impairments{Loss: 1.5}},
+		{"negative duplicate", Impairments{Duplicate: -0.1}},
+		{"reorder above 1", Impairments{Reorder: 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := NewReflector("127.0.0.1:0", tc.impairments)
+			if err == nil {
+				r.Close()
+				t.Fatal("no error")
+			}
+			if code := ErrorCode(err); code != CodeInvalidArgs {
+				t.Errorf("ErrorCode = %s, want %s", code, CodeInvalidArgs)
+			}
+		})
+	}
+}
+
+// readDatagrams reads n datagrams from c, failing the test if they don't arrive within a second
+func readDatagrams(t *testing.T, c net.Conn, n int) []string {
+	t.Helper()
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, 1500)
+	var got []string
+	for len(got) < n {
+		l, err := c.Read(b)
+		if err != nil {
+			t.Fatalf("after %q: %v", got, err)
+		}
+		got = append(got, string(b[:l]))
+	}
+	return got
+}
@@ -0,0 +1,38 @@
+package ping
+
+// reordering summarizes the order in which replies arrived, using the metrics of RFC 4737. arrivals
+// holds the sequence number of each (non-duplicate) reply, in the order they were received.
+//
+// A reply is reordered if its sequence number is lower than one that arrived before it. Its extent
+// is how many replies it arrived later than it should have: the distance back to the earliest
+// arrival with a higher sequence number (RFC 4737, section 4.2).
+// returns:
+// float32 - the fraction of replies that were reordered
+// int - the largest reordering extent seen, in replies
+func reordering(arrivals []int) (float32, int) {
+
+	if len(arrivals) == 0 {
+		return 0, 0
+	}
+
+	var reordered, maxExtent int
+	nextExp := arrivals[0]
+	for i, seq := range arrivals {
+		if seq >= nextExp {
+			nextExp = seq + 1
+			continue
+		}
+
+		reordered++
+		for j := 0; j < i; j++ {
+			if arrivals[j] > seq {
+				if i-j > maxExtent {
+					maxExtent = i - j
+				}
+				break
+			}
+		}
+	}
+
+	return float32(reordered) / float32(len(arrivals)), maxExtent
+}
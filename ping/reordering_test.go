@@ -0,0 +1,29 @@
+package ping
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReordering(t *testing.T) {
+	for _, tc := range []struct {
+		arrivals []int
+		ratio    float32
+		extent   int
+	}{
+		{nil, 0, 0},
+		{[]int{0, 1, 2, 3}, 0, 0},
+		{[]int{0, 2, 4}, 0, 0}, // losses aren't reordering
+		{[]int{1, 0, 2, 3}, 0.25, 1},
+		{[]int{0, 2, 3, 1, 4}, 0.2, 2},
+		{[]int{3, 0, 1, 2}, 0.75, 3},
+		{[]int{0, 1, 3, 2, 5, 4}, 1.0 / 3, 1},
+		// A run can start part way through, as when the first replies were lost
+		{[]int{5, 6, 7}, 0, 0},
+	} {
+		ratio, extent := reordering(tc.arrivals)
+		if math.Abs(float64(ratio-tc.ratio)) > 1e-6 || extent != tc.extent {
+			t.Errorf("reordering(%v) = %v, %d, want %v, %d", tc.arrivals, ratio, extent, tc.ratio, tc.extent)
+		}
+	}
+}
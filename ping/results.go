@@ -0,0 +1,64 @@
+package ping
+
+import (
+	"encoding/json"
+)
+
+// Results is everything gathered during a testlet run. Metrics is what Run returns and is always
+// present; the remaining fields are only populated by the modes that produce them.
+type Results struct {
+	Metrics map[string]float32
+
+	// Responders is populated in multi_responder mode
+	Responders []Responder
+
+	// Hosts is populated in sweep mode, in the order the addresses were expanded
+	Hosts []HostStatus
+
+	// LinkLayerAddress is the target's advertised link-layer address in ndp mode
+	LinkLayerAddress string
+
+	// Interface is the state of the queried interface in probe mode, from the last response received
+	Interface *InterfaceStatus
+
+	// Timestamping is where echo latencies were timed: "kernel", "kernel-rx" (kernel receive
+	// times only) or "userspace". Only set for icmp probes of a unicast target that replied.
+	Timestamping string
+
+	// Probes holds a record of every probe, when asked for with the records arg
+	Probes []ProbeRecord
+
+	// Interrupted is set when the run was cut short, so the results only cover part of it
+	Interrupted bool
+}
+
+// MarshalJSON renders the metrics as top-level keys (the same JSON the testlet has always printed),
+// with any additional details alongside them
+func (r Results) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	for k, v := range r.Metrics {
+		out[k] = v
+	}
+	if r.Responders != nil {
+		out["responders"] = r.Responders
+	}
+	if r.Hosts != nil {
+		out["hosts"] = r.Hosts
+	}
+	if r.LinkLayerAddress != "" {
+		out["link_layer_address"] = r.LinkLayerAddress
+	}
+	if r.Interface != nil {
+		out["interface"] = r.Interface
+	}
+	if r.Probes != nil {
+		out["probes"] = r.Probes
+	}
+	if r.Interrupted {
+		out["interrupted"] = true
+	}
+	if r.Timestamping != "" {
+		out["timestamping"] = r.Timestamping
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,69 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Probe schedules
+const (
+	scheduleFixed   = "fixed"   // every interval
+	schedulePoisson = "poisson" // exponentially distributed gaps averaging interval (RFC 2330, section 11.1.1)
+	scheduleUniform = "uniform" // gaps uniformly distributed within jitter either side of interval
+)
+
+// schedule picks the gaps between the times successive probes are sent. Fixed gaps can fall into
+// step with periodic events in the network (routing updates, polling, timers) and so see either
+// too much or too little of them; random gaps avoid this, while a seed keeps them reproducible.
+type schedule struct {
+	kind     string
+	interval time.Duration
+	jitter   time.Duration
+	rand     *rand.Rand
+}
+
+// newSchedule reads the schedule, interval_ms, jitter_ms and schedule_seed args. jitter_ms only
+// applies to the uniform schedule, and defaults to half the interval.
+func newSchedule(args map[string]interface{}) (*schedule, error) {
+
+	kind := stringArg(args, "schedule", scheduleFixed)
+	intervalMs := intArg(args, "interval_ms", 1000)
+	jitterMs := intArg(args, "jitter_ms", intervalMs/2)
+
+	switch kind {
+	case scheduleFixed, schedulePoisson, scheduleUniform:
+	default:
+		return nil, fmt.Errorf("unsupported schedule '%s'", kind)
+	}
+	if intervalMs < 0 {
+		return nil, errors.New("interval_ms can't be negative")
+	}
+	if jitterMs < 0 || jitterMs > intervalMs {
+		return nil, errors.New("jitter_ms must be between 0 and interval_ms")
+	}
+
+	seed := int64(intArg(args, "schedule_seed", 0))
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &schedule{
+		kind:     kind,
+		interval: time.Duration(intervalMs) * time.Millisecond,
+		jitter:   time.Duration(jitterMs) * time.Millisecond,
+		rand:     rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// next returns the gap between sending one probe and the next
+func (s *schedule) next() time.Duration {
+	switch s.kind {
+	case schedulePoisson:
+		return time.Duration(s.rand.ExpFloat64() * float64(s.interval))
+	case scheduleUniform:
+		return s.interval - s.jitter + time.Duration(s.rand.Int63n(int64(2*s.jitter)+1))
+	}
+	return s.interval
+}
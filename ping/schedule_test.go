@@ -0,0 +1,103 @@
+package ping
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// gaps returns the first n gaps of the schedule described by args
+func gaps(t *testing.T, args map[string]interface{}, n int) []time.Duration {
+	t.Helper()
+
+	s, err := newSchedule(args)
+	if err != nil {
+		t.Fatalf("newSchedule(%v): %v", args, err)
+	}
+	d := make([]time.Duration, n)
+	for i := range d {
+		d[i] = s.next()
+	}
+	return d
+}
+
+func TestScheduleRange(t *testing.T) {
+	for _, tc := range []struct {
+		args     map[string]interface{}
+		min, max time.Duration
+	}{
+		{map[string]interface{}{}, time.Second, time.Second},
+		{map[string]interface{}{"interval_ms": 0}, 0, 0},
+		{map[string]interface{}{"interval_ms": 100, "jitter_ms": 50}, 100 * time.Millisecond, 100 * time.Millisecond},
+		{map[string]interface{}{"schedule": "uniform", "interval_ms": 100}, 50 * time.Millisecond, 150 * time.Millisecond},
+		{map[string]interface{}{"schedule": "uniform", "interval_ms": 100, "jitter_ms": 10}, 90 * time.Millisecond, 110 * time.Millisecond},
+		{map[string]interface{}{"schedule": "uniform", "interval_ms": 100, "jitter_ms": 0}, 100 * time.Millisecond, 100 * time.Millisecond},
+		{map[string]interface{}{"schedule": "uniform", "interval_ms": 100, "jitter_ms": 100}, 0, 200 * time.Millisecond},
+	} {
+		var total time.Duration
+		for _, d := range gaps(t, tc.args, 1000) {
+			if d < tc.min || d > tc.max {
+				t.Errorf("%v: gap %v outside [%v, %v]", tc.args, d, tc.min, tc.max)
+				break
+			}
+			total += d
+		}
+
+		// Random gaps average out at the interval
+		mean, want := total/1000, (tc.min+tc.max)/2
+		if mean < want*9/10 || mean > want*11/10 {
+			t.Errorf("%v: mean gap %v, want about %v", tc.args, mean, want)
+		}
+	}
+}
+
+func TestSchedulePoisson(t *testing.T) {
+	args := map[string]interface{}{"schedule": "poisson", "interval_ms": 100, "schedule_seed": 1}
+
+	var total time.Duration
+	varied := false
+	d := gaps(t, args, 10000)
+	for _, g := range d {
+		if g < 0 {
+			t.Fatalf("negative gap %v", g)
+		}
+		varied = varied || g != d[0]
+		total += g
+	}
+	if !varied {
+		t.Error("every gap was the same")
+	}
+	if mean := total / 10000; mean < 95*time.Millisecond || mean > 105*time.Millisecond {
+		t.Errorf("mean gap %v, want about 100ms", mean)
+	}
+}
+
+func TestScheduleSeed(t *testing.T) {
+	for _, kind := range []string{schedulePoisson, scheduleUniform} {
+		args := map[string]interface{}{"schedule": kind, "interval_ms": 100, "schedule_seed": 42}
+
+		first, second := gaps(t, args, 20), gaps(t, args, 20)
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("%s: the same seed gave %v and %v", kind, first, second)
+		}
+
+		args["schedule_seed"] = 43
+		if other := gaps(t, args, 20); reflect.DeepEqual(first, other) {
+			t.Errorf("%s: seeds 42 and 43 gave the same gaps %v", kind, first)
+		}
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	for _, args := range []map[string]interface{}{
+		{"schedule": "random"},
+		{"interval_ms": -1},
+		{"jitter_ms": -1},
+		{"schedule": "uniform", "interval_ms": 100, "jitter_ms": 101},
+		{"interval_ms": 0, "jitter_ms": 1},
+	} {
+		if s, err := newSchedule(args); err == nil {
+			t.Errorf("newSchedule(%v) = %+v, want an error", args, *s)
+		}
+	}
+}
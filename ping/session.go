@@ -0,0 +1,487 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Timestamping sources, reported with the results of echo probes
+const (
+	timestampsKernel   = "kernel"    // send and receive times both from the kernel
+	timestampsKernelRX = "kernel-rx" // receive time from the kernel, send time from userspace
+	timestampsUser     = "userspace" // both from userspace, including any scheduling delays
+)
+
+// timestampRank orders the timestamping sources from least to most accurate
+var timestampRank = map[string]int{
+	timestampsUser:     0,
+	timestampsKernelRX: 1,
+	timestampsKernel:   2,
+}
+
+// echoPayloadMarker follows the send time in every echo request we send
+const echoPayloadMarker = "hanshotfirst"
+
+// Sizes of echo request payloads (the size arg). The smallest carries just the send time and the
+// marker; the largest still fits in an IPv4 datagram.
+const (
+	minEchoSize = 8 + len(echoPayloadMarker)
+	maxEchoSize = 65000
+)
+
+// echoPayload is the data carried by an echo request: like iputils ping, it starts with the time
+// the request was sent (in nanoseconds since the Unix epoch), which the target echoes back, so
+// that the RTT can be worked out from the reply alone. The rest of the payload's size bytes are
+// filled with repeats of the marker.
+func echoPayload(sent time.Time, size int) []byte {
+	if size < minEchoSize {
+		size = minEchoSize
+	}
+	b := make([]byte, size)
+	binary.BigEndian.PutUint64(b, uint64(sent.UnixNano()))
+	for i := 8; i < size; i += copy(b[i:], echoPayloadMarker) {
+	}
+	return b
+}
+
+// payloadTime returns the send time from an echo reply's payload, if it carries one of ours
+func payloadTime(data []byte) (time.Time, bool) {
+	if len(data) < minEchoSize || string(data[8:minEchoSize]) != echoPayloadMarker {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data))), true
+}
+
+// LateReply is an echo reply that arrived after its request had timed out. Its latency is
+// measured from the send time carried in the request, and Seq is the request's index in the run.
+type LateReply struct {
+	Seq       int     `json:"seq"`
+	LatencyMs float32 `json:"latency_ms"`
+}
+
+// echoReply is the outcome of a single echo request
+type echoReply struct {
+	latency float32
+	replied bool
+
+	// timestamps is where the send and receive times behind latency came from
+	timestamps string
+
+	// Details of the reply (or the source of an ICMP error, for from)
+	from string
+	ttl  int
+	size int
+}
+
+// echoSession sends a series of echo requests to one target over a single socket (or, in sweep
+// mode, to any number of targets of the same address family; see pingTarget). Replies are
+// read by a goroutine of its own and handed to whichever request is waiting for them, so requests
+// can go out on schedule while earlier ones are still waiting. Replies that miss their request's
+// timeout are still picked up, and since every request carries its send time they can be measured
+// without keeping any state per request.
+//
+// Holding one socket for the whole series also keeps the kernel timestamping packets throughout:
+// it only does so while some socket has asked for it, and switches this on and off asynchronously,
+// so a short-lived socket per request often misses out.
+type echoSession struct {
+	t Target
+	c *conn
+
+	// id identifies the session's requests (on raw sockets), so that sessions running at the
+	// same time don't take each other's replies
+	id int
+
+	// size is the payload size of each request
+	size int
+
+	// late, if set, is called with each reply to a request that had already timed out, from the
+	// goroutine reading replies. It must be set before the first request is sent.
+	late func(LateReply)
+
+	// sendMu serializes sending, so that each request collects its own send timestamp from the
+	// kernel
+	sendMu sync.Mutex
+
+	// mu guards the rest, which is shared with the goroutine reading replies
+	mu sync.Mutex
+
+	// pending holds the indexes of requests that haven't been answered yet, and last the index
+	// of the latest one sent. Only the low 16 bits of an index go out as the sequence number, so
+	// replies are mapped back to the latest request they can belong to (see index).
+	pending map[int]bool
+	last    int
+
+	// targets holds the address each request was sent to, by index, which its replies must come
+	// from. next is the index handed out by nextSeq.
+	targets map[int]net.IP
+	next    int
+
+	// waiters holds the requests still waiting for their reply, by index
+	waiters map[int]chan *receivedReply
+
+	// arrivals holds the index of every reply (late or not) in the order they arrived, and
+	// duplicates counts the replies to requests that had already been answered (RFC 5560)
+	arrivals   []int
+	duplicates int
+
+	// The goroutine reading replies is started along with the first request, and closes received
+	// once the socket is closed. stop is closed when the session is interrupted.
+	start    sync.Once
+	received chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// sessions counts the echo sessions opened so far, to give each its own identifier
+var sessions uint32
+
+// newEchoSession opens a socket for pinging t, with the given send options
+func newEchoSession(t Target, o sendOptions) (*echoSession, error) {
+
+	// Datagram ("udp4"/"udp6") sockets are used when raw sockets aren't permitted. Targets that
+	// drop ICMP entirely can be measured with PingTCP or PingUDP instead (the "protocol" arg to Run).
+	c, err := listenWith(t, o)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Opened %s socket", c.network)
+
+	c.enableTimestamps()
+	if err := setRecvHops(c.PacketConn); err != nil {
+		log.Debugf("Unable to read the TTL of echo replies: %v", err)
+	}
+
+	return &echoSession{
+		t:        t,
+		c:        c,
+		id:       (os.Getpid() + int(atomic.AddUint32(&sessions, 1)) - 1) & 0xffff,
+		size:     o.size,
+		pending:  map[int]bool{},
+		last:     -1,
+		targets:  map[int]net.IP{},
+		waiters:  map[int]chan *receivedReply{},
+		received: make(chan struct{}),
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Close closes the session's socket
+func (s *echoSession) Close() error {
+	return s.c.Close()
+}
+
+// ping sends an echo request for the probe with index seq, and waits up to icmpTimeout seconds for
+// the reply. It can be called from several goroutines at once. Where the kernel can timestamp
+// packets, its send and receive times are used for the latency, so that time spent in Go (such as
+// scheduling delays and GC pauses) isn't counted; otherwise it falls back to timing in userspace.
+func (s *echoSession) ping(seq, icmpTimeout int) (echoReply, error) {
+	return s.pingTarget(s.t, seq, icmpTimeout)
+}
+
+// nextSeq returns the index for the next request of a session shared between targets
+func (s *echoSession) nextSeq() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.next
+	s.next++
+	return seq
+}
+
+// pingTarget is ping, but for any target of the session's address family. Sessions shared
+// between targets take their indexes from nextSeq.
+func (s *echoSession) pingTarget(t Target, seq, icmpTimeout int) (echoReply, error) {
+
+	reply := echoReply{timestamps: timestampsUser, ttl: -1}
+
+	wb, err := echoRequest(t, s.id, seq, s.size)
+	if err != nil {
+		return reply, err
+	}
+
+	s.start.Do(func() {
+		go s.receive()
+	})
+
+	// The request is waiting before it's sent, so that even the quickest reply finds it
+	ch := make(chan *receivedReply, 1)
+	s.mu.Lock()
+	s.pending[seq] = true
+	s.targets[seq] = t.IP
+	s.waiters[seq] = ch
+	if seq > s.last {
+		s.last = seq
+	}
+	s.mu.Unlock()
+
+	// The request's send timestamp is usually on the error queue by the time WriteTo returns
+	s.sendMu.Lock()
+	_, err = s.c.WriteTo(wb, t.addr(s.c.network))
+	sent, txKernel := s.c.txTimestamp()
+	s.sendMu.Unlock()
+
+	if err != nil {
+		s.mu.Lock()
+		delete(s.pending, seq)
+		delete(s.targets, seq)
+		delete(s.waiters, seq)
+		s.mu.Unlock()
+		return reply, err
+	}
+
+	timeout := time.NewTimer(time.Duration(icmpTimeout) * time.Second)
+	defer timeout.Stop()
+
+	var m *receivedReply
+	select {
+	case m = <-ch:
+	case <-timeout.C:
+	case <-s.stop:
+	}
+	if m == nil {
+		s.mu.Lock()
+		delete(s.waiters, seq)
+		s.mu.Unlock()
+
+		// The reply may have been handed over in the meantime
+		select {
+		case m = <-ch:
+		default:
+			log.Debugf("Ping timeout on %v", t)
+			return reply, nil
+		}
+	}
+
+	if m.err != nil {
+		reply.from = m.from
+		return reply, m.err
+	}
+
+	// Any send timestamp from before the request was built belongs to an earlier one. A later
+	// look on the error queue is only safe while no later request has been sent.
+	if !txKernel || sent.Before(m.sent) {
+		s.sendMu.Lock()
+		s.mu.Lock()
+		latest := s.last == seq
+		s.mu.Unlock()
+		if latest {
+			sent, txKernel = s.c.txTimestamp()
+		}
+		s.sendMu.Unlock()
+	}
+	if !txKernel || sent.Before(m.sent) {
+		sent, txKernel = m.sent, false
+	}
+
+	switch {
+	case m.rxKernel && txKernel:
+		reply.timestamps = timestampsKernel
+	case m.rxKernel:
+		reply.timestamps = timestampsKernelRX
+	}
+
+	// Return the latency in milliseconds, and acknowledge that a reply was received
+	reply.latency = durationMs(m.received.Sub(sent))
+	reply.replied = true
+	reply.from, reply.ttl, reply.size = m.from, m.ttl, m.size
+	return reply, nil
+}
+
+// index returns the index of the latest request sent with the (16-bit) sequence number seq.
+// Requests wrap around after 65536 of them, by which time the earlier one has long timed out.
+// Must be called with s.mu held.
+func (s *echoSession) index(seq int) int {
+	return s.last - (s.last-seq)&0xffff
+}
+
+// interruptGrace is how long the reply to a request in flight is waited for once a run is
+// interrupted
+const interruptGrace = 500 * time.Millisecond
+
+// graceContext returns a context that's done interruptGrace after ctx is, giving a probe that's in
+// flight when ctx is cancelled the chance to complete
+func graceContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	gctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(interruptGrace, cancel)
+	})
+	return gctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// cutShort cuts short the wait for replies on c, for probes that don't use an echo session, once
+// ctx has been cancelled for interruptGrace. The returned function stops it.
+func cutShort(ctx context.Context, c interface{ SetReadDeadline(time.Time) error }) func() {
+	gctx, cancel := graceContext(ctx)
+	stop := context.AfterFunc(gctx, func() {
+		c.SetReadDeadline(time.Now())
+	})
+	return func() {
+		stop()
+		cancel()
+	}
+}
+
+// interrupt gives up on the replies still awaited once grace has passed. It can be called while
+// requests are waiting.
+func (s *echoSession) interrupt(grace time.Duration) {
+	time.AfterFunc(grace, func() {
+		s.stopOnce.Do(func() {
+			close(s.stop)
+		})
+	})
+}
+
+// drain gives replies already on their way, such as late replies to the last request, a moment
+// to arrive, then closes the socket. The session's counts are final once it returns.
+func (s *echoSession) drain() {
+	time.Sleep(10 * time.Millisecond)
+	s.c.Close()
+
+	// If no request was ever sent, there's no goroutine to wait for
+	s.start.Do(func() {
+		close(s.received)
+	})
+	<-s.received
+}
+
+// receivedReply is an echo reply (or ICMP error) in response to one of the session's requests
+type receivedReply struct {
+	seq      int
+	sent     time.Time // from the payload
+	received time.Time
+	rxKernel bool // received is the kernel's timestamp
+
+	from string
+	ttl  int // -1 if unknown
+	size int // of the ICMP message
+
+	// err is set when the request was answered with an ICMP error instead
+	err *probeError
+}
+
+// receive reads replies (and ICMP errors) to the session's requests until the socket is closed,
+// handing each to its request, or reporting it as late if the request has given up on it.
+func (s *echoSession) receive() {
+	defer close(s.received)
+
+	rb := make([]byte, receiveBufferSize(s.size))
+	oob := make([]byte, 256)
+	for {
+		n, oobn, peer, err := s.c.readMsg(rb, oob)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Debugf("Error reading echo replies: %v", err)
+			continue
+		}
+
+		received, rxKernel := rxTimestamp(oob[:oobn])
+		if !rxKernel {
+			received = time.Now()
+		}
+
+		rm, err := icmp.ParseMessage(s.c.proto, rb[:n])
+		if err != nil {
+			log.Debugf("Ignoring unparseable message from %v: %v", peer, err)
+			continue
+		}
+
+		// ICMP errors about our requests can come from anywhere along the path
+		if typ, quoted := quotedMessage(rm); typ == ipv4.ICMPTypeEcho || typ == ipv6.ICMPTypeEchoRequest {
+			if len(quoted) < 8 || (s.c.raw() && int(binary.BigEndian.Uint16(quoted[4:6])) != s.id) {
+				continue
+			}
+			s.deliver(int(binary.BigEndian.Uint16(quoted[6:8])), nil, &receivedReply{
+				received: received,
+				from:     peerAddress(peer),
+				err:      &probeError{Type: rm.Type, Code: rm.Code, From: peerAddress(peer)},
+			})
+			continue
+		}
+
+		switch rm.Type {
+		case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+		default:
+			// Including our own request, looped back to us when pinging a local address
+			continue
+		}
+
+		echo, ok := rm.Body.(*icmp.Echo)
+		if !ok || (s.c.raw() && echo.ID != s.id) {
+			// Reply to somebody else's request
+			continue
+		}
+		sent, ok := payloadTime(echo.Data)
+		if !ok {
+			continue
+		}
+
+		s.deliver(echo.Seq, peerIP(peer), &receivedReply{
+			sent:     sent,
+			received: received,
+			rxKernel: rxKernel,
+			from:     peerAddress(peer),
+			ttl:      parseHops(oob[:oobn]),
+			size:     n,
+		})
+	}
+}
+
+// deliver hands m, received for the request with sequence number seq, to the request if it's
+// still waiting. Otherwise it's counted as a duplicate, or reported as late. Duplicate ICMP
+// errors are ignored. Echo replies come from peer, which must be the request's target: a raw
+// socket sees every ICMP message arriving at the host, including replies for probes running
+// alongside this one. (ICMP errors, with a nil peer, can come from anywhere along the path.)
+func (s *echoSession) deliver(seq int, peer net.IP, m *receivedReply) {
+	s.mu.Lock()
+	m.seq = s.index(seq)
+	if peer != nil && !peer.Equal(s.targets[m.seq]) {
+		s.mu.Unlock()
+		return
+	}
+	if !s.pending[m.seq] {
+		if m.err == nil {
+			log.Debugf("Duplicate reply from %v for seq %d", peer, m.seq)
+			s.duplicates++
+		}
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, m.seq)
+	if m.err == nil {
+		s.arrivals = append(s.arrivals, m.seq)
+	}
+
+	ch, waiting := s.waiters[m.seq]
+	delete(s.waiters, m.seq)
+	s.mu.Unlock()
+
+	switch {
+	case waiting:
+		ch <- m
+	case m.err != nil:
+		log.Debugf("Late %v for seq %d", m.err, m.seq)
+	default:
+		late := LateReply{Seq: m.seq, LatencyMs: durationMs(m.received.Sub(m.sent))}
+		log.Debugf("Late reply from %v for seq %d after %f ms", m.from, late.Seq, late.LatencyMs)
+		if s.late != nil {
+			s.late(late)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ping
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSessionIndex(t *testing.T) {
+	for _, tc := range []struct {
+		last, seq, want int
+	}{
+		{5, 5, 5},
+		{5, 3, 3},
+		{65535, 65535, 65535},
+		{65535, 0, 0},
+
+		// After the wrap, sequence numbers map to the latest request sent with them
+		{65536, 0, 65536},
+		{65536, 65535, 65535},
+		{65540, 4, 65540},
+		{65540, 2, 65538},
+		{65540, 65530, 65530},
+		{131075, 3, 131075},
+		{131075, 65535, 131071},
+	} {
+		s := &echoSession{last: tc.last}
+		if got := s.index(tc.seq); got != tc.want {
+			t.Errorf("last %d: index(%d) = %d, want %d", tc.last, tc.seq, got, tc.want)
+		}
+	}
+}
+
+func TestSessionDeliver(t *testing.T) {
+	target := net.ParseIP("192.0.2.1")
+	sent := time.Unix(1500000000, 0)
+
+	// 65535 has timed out, and 65536 (sequence number 0) is still waiting
+	waiting := make(chan *receivedReply, 1)
+	var late []LateReply
+	s := &echoSession{
+		pending: map[int]bool{65535: true, 65536: true},
+		last:    65536,
+		targets: map[int]net.IP{65535: target, 65536: target},
+		waiters: map[int]chan *receivedReply{65536: waiting},
+		late:    func(l LateReply) { late = append(late, l) },
+	}
+	reply := func() *receivedReply {
+		return &receivedReply{sent: sent, received: sent.Add(20 * time.Millisecond)}
+	}
+
+	for _, tc := range []struct {
+		name       string
+		seq        int
+		peer       net.IP
+		duplicates int
+	}{
+		{"after the wrap", 0, target, 0},
+		{"duplicate", 0, target, 1},
+		{"late, before the wrap", 65535, target, 1},
+		{"duplicate late", 65535, target, 2},
+		{"from another host", 65535, net.ParseIP("192.0.2.2"), 2},
+	} {
+		s.deliver(tc.seq, tc.peer, reply())
+		if s.duplicates != tc.duplicates {
+			t.Errorf("%s: %d duplicates, want %d", tc.name, s.duplicates, tc.duplicates)
+		}
+	}
+
+	select {
+	case m := <-waiting:
+		if m.seq != 65536 {
+			t.Errorf("waiting request got the reply for %d", m.seq)
+		}
+	default:
+		t.Error("waiting request got no reply")
+	}
+	if want := []LateReply{{Seq: 65535, LatencyMs: 20}}; !reflect.DeepEqual(late, want) {
+		t.Errorf("late replies %v, want %v", late, want)
+	}
+	if want := []int{65536, 65535}; !reflect.DeepEqual(s.arrivals, want) {
+		t.Errorf("arrivals %v, want %v", s.arrivals, want)
+	}
+	if len(s.pending) != 0 {
+		t.Errorf("still pending: %v", s.pending)
+	}
+}
+
+func TestEchoPayload(t *testing.T) {
+	sent := time.Unix(1500000000, 123456789)
+
+	for _, tc := range []struct {
+		size, want int
+	}{
+		{0, minEchoSize},
+		{minEchoSize, minEchoSize},
+		{minEchoSize + 5, minEchoSize + 5},
+		{1000, 1000},
+	} {
+		b := echoPayload(sent, tc.size)
+		if len(b) != tc.want {
+			t.Errorf("size %d: %d bytes, want %d", tc.size, len(b), tc.want)
+		}
+		if got, ok := payloadTime(b); !ok || !got.Equal(sent) {
+			t.Errorf("size %d: payloadTime = %v, %t, want %v", tc.size, got, ok, sent)
+		}
+	}
+
+	// Payloads that aren't ours
+	for _, b := range [][]byte{
+		nil,
+		echoPayload(sent, 0)[:minEchoSize-1],
+		make([]byte, 64),
+	} {
+		if got, ok := payloadTime(b); ok {
+			t.Errorf("payloadTime(%q) = %v, want none", b, got)
+		}
+	}
+}
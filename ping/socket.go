@@ -0,0 +1,229 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+)
+
+// ICMP protocol numbers, as expected by icmp.ParseMessage
+const (
+	protocolICMP     = 1
+	protocolIPv6ICMP = 58
+)
+
+// conn is an ICMP socket for a single address family.
+//
+// These are opened here rather than with icmp.ListenPacket, because the socket option helpers in
+// the vendored ipv4/ipv6 packages can't find the file descriptor on newer Go releases, and
+// icmp.PacketConn doesn't expose the underlying connection for us to do it ourselves.
+type conn struct {
+	net.PacketConn
+
+	// network is one of "ip4:icmp", "ip6:ipv6-icmp", "udp4" or "udp6"
+	network string
+
+	// proto is the ICMP protocol number used when parsing replies
+	proto int
+
+	// txTimestamps is set once the kernel has been asked for send timestamps
+	txTimestamps bool
+}
+
+// sendOptions are the settings of the socket echo requests are sent from (the size, ttl, dscp,
+// source and interface args). The zero value leaves everything to the system.
+type sendOptions struct {
+	size   int
+	ttl    int
+	dscp   int
+	source net.IP
+	iface  *net.Interface
+}
+
+// newSendOptions reads the send options from args, for pinging t
+func newSendOptions(args map[string]interface{}, t Target) (sendOptions, error) {
+	o := sendOptions{
+		size: intArg(args, "size", minEchoSize),
+		ttl:  intArg(args, "ttl", 0),
+		dscp: intArg(args, "dscp", 0),
+	}
+
+	if source := stringArg(args, "source", ""); source != "" {
+		o.source = net.ParseIP(source)
+		if o.source == nil {
+			return o, argError(fmt.Errorf("source '%s' is not a valid IP address", source))
+		}
+		if (o.source.To4() != nil) != t.IsIPv4() {
+			return o, argError(fmt.Errorf("source %s and target %s are of different address families", source, t))
+		}
+		if !localAddress(o.source) {
+			return o, argError(fmt.Errorf("source %s isn't an address of this host", source))
+		}
+	}
+
+	if name := stringArg(args, "interface", ""); name != "" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return o, argError(fmt.Errorf("no interface named '%s'", name))
+		}
+		o.iface = ifi
+	}
+	return o, nil
+}
+
+// localAddress checks whether ip is assigned to one of this host's interfaces
+func localAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// receiveBufferSize is big enough to read a reply carrying size bytes of data, along with the
+// IPv4 header raw sockets include
+func receiveBufferSize(size int) int {
+	if size+128 > 1500 {
+		return size + 128
+	}
+	return 1500
+}
+
+// listen opens an ICMP socket suitable for reaching t on all interfaces.
+// This will attempt a raw ICMP socket first, then fall back to UDP
+// (an unprivileged "ping socket" where the platform supports it)
+func listen(t Target) (*conn, error) {
+	return listenWith(t, sendOptions{})
+}
+
+// listenWith is the same as listen, but applies the send options to the socket
+func listenWith(t Target, o sendOptions) (*conn, error) {
+	network, addy, proto := "ip4:icmp", "0.0.0.0", protocolICMP
+	if !t.IsIPv4() {
+		network, addy, proto = "ip6:ipv6-icmp", "::", protocolIPv6ICMP
+	}
+	if o.source != nil {
+		addy = o.source.String()
+	}
+
+	c, err := net.ListenPacket(network, addy)
+	if err != nil {
+		network = "udp4"
+		if !t.IsIPv4() {
+			network = "udp6"
+		}
+		c, err = listenDatagram(network, addy)
+		if err != nil {
+			return nil, socketError(err)
+		}
+	}
+
+	ic := &conn{PacketConn: c, network: network, proto: proto}
+	if err := ic.apply(o); err != nil {
+		ic.Close()
+		return nil, socketError(err)
+	}
+	return ic, nil
+}
+
+// apply sets the socket options called for by o
+func (c *conn) apply(o sendOptions) error {
+	if o.iface != nil {
+		if err := c.bindToInterface(o.iface); err != nil {
+			return err
+		}
+	}
+	if o.ttl > 0 {
+		if err := c.SetUnicastHops(o.ttl); err != nil {
+			return err
+		}
+	}
+	if o.dscp > 0 {
+		if err := c.SetDSCP(o.dscp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// raw returns true if this is a raw socket, where the kernel leaves the echo identifier alone
+// and we see every ICMP message arriving at the host (not just replies to our own requests)
+func (c *conn) raw() bool {
+	return !strings.HasPrefix(c.network, "udp")
+}
+
+// requireRawSocket checks that a raw socket can be opened for reaching t, for modes that can't
+// work with datagram ones
+func requireRawSocket(t Target, what string) error {
+	c, err := listen(t)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if !c.raw() {
+		return rawSocketRequired(what)
+	}
+	return nil
+}
+
+// SocketMode reports which kind of ICMP socket this process is able to open: "raw", or
+// "datagram" where only unprivileged ping sockets are permitted
+func SocketMode() (string, error) {
+	c, err := listen(Target{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	if c.raw() {
+		return "raw", nil
+	}
+	return "datagram", nil
+}
+
+// ReadFrom reads a single ICMP message into b, without any IP header
+func (c *conn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, _, peer, err := c.readMsg(b, nil)
+	return n, peer, err
+}
+
+// readMsg reads a single ICMP message into b, like ReadFrom, along with any control
+// messages (such as timestamps) into oob
+func (c *conn) readMsg(b, oob []byte) (n, oobn int, peer net.Addr, err error) {
+	switch pc := c.PacketConn.(type) {
+	case *net.IPConn:
+		var addr *net.IPAddr
+		if n, oobn, _, addr, err = pc.ReadMsgIP(b, oob); err == nil {
+			peer = addr
+		}
+	case *net.UDPConn:
+		var addr *net.UDPAddr
+		if n, oobn, _, addr, err = pc.ReadMsgUDP(b, oob); err == nil {
+			peer = addr
+		}
+	default:
+		n, peer, err = c.PacketConn.ReadFrom(b)
+	}
+	if err != nil {
+		return n, oobn, peer, err
+	}
+
+	// Raw IPv4 sockets hand us the IP header as well (ReadMsgIP, unlike ReadFrom, leaves it
+	// in place), as do datagram ICMP sockets on Darwin
+	if (c.network == "ip4:icmp" || runtime.GOOS == "darwin" && c.network == "udp4") && n > 0 {
+		hlen := int(b[0]&0x0f) << 2
+		if hlen > n {
+			return 0, oobn, peer, errors.New("truncated IPv4 header")
+		}
+		n = copy(b, b[hlen:n])
+	}
+	return n, oobn, peer, nil
+}
@@ -0,0 +1,78 @@
+//go:build !linux && !darwin
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"time"
+)
+
+// errUnsupportedPlatform is returned by the socket options, which are only implemented for the
+// platforms the testlet runs on. The package still builds elsewhere, and checkSystem turns such
+// platforms away before a run is attempted.
+var errUnsupportedPlatform = errors.New("ICMP socket options aren't supported on " + runtime.GOOS)
+
+// listenDatagram is unsupported on this platform
+func listenDatagram(network, addy string) (net.PacketConn, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// setsockoptInt is unsupported on this platform
+func (c *conn) setsockoptInt(level, opt, value int) error {
+	return errUnsupportedPlatform
+}
+
+// setsockoptInt is unsupported on this platform
+func setsockoptInt(c interface{}, level, opt, value int) error {
+	return errUnsupportedPlatform
+}
+
+// setRecvHops is unsupported on this platform
+func setRecvHops(c interface{}) error {
+	return errUnsupportedPlatform
+}
+
+// parseHops never finds a TTL or hop limit on this platform
+func parseHops(oob []byte) int {
+	return -1
+}
+
+// SetBroadcast is unsupported on this platform
+func (c *conn) SetBroadcast(on bool) error {
+	return errUnsupportedPlatform
+}
+
+// SetMulticastHops is unsupported on this platform
+func (c *conn) SetMulticastHops(hops int) error {
+	return errUnsupportedPlatform
+}
+
+// SetDSCP is unsupported on this platform
+func (c *conn) SetDSCP(dscp int) error {
+	return errUnsupportedPlatform
+}
+
+// SetUnicastHops is unsupported on this platform
+func (c *conn) SetUnicastHops(hops int) error {
+	return errUnsupportedPlatform
+}
+
+// bindToInterface is unsupported on this platform
+func (c *conn) bindToInterface(ifi *net.Interface) error {
+	return errUnsupportedPlatform
+}
+
+// enableTimestamps does nothing on this platform, so times always come from userspace
+func (c *conn) enableTimestamps() {}
+
+// rxTimestamp never finds a kernel timestamp on this platform
+func rxTimestamp(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// txTimestamp is unsupported on this platform
+func (c *conn) txTimestamp() (time.Time, bool) {
+	return time.Time{}, false
+}
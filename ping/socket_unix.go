@@ -0,0 +1,147 @@
+//go:build linux || darwin
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Darwin-only option that strips the IPv4 header from datagram ICMP sockets
+const sysIP_STRIPHDR = 0x17
+
+// listenDatagram opens a non-privileged datagram ICMP socket
+func listenDatagram(network, addy string) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, protocolICMP
+	sa4 := &syscall.SockaddrInet4{}
+	copy(sa4.Addr[:], net.ParseIP(addy).To4())
+	var sa syscall.Sockaddr = sa4
+	if network == "udp6" {
+		family, proto = syscall.AF_INET6, protocolIPv6ICMP
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], net.ParseIP(addy).To16())
+		sa = sa6
+	}
+
+	s, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	defer syscall.Close(s)
+
+	if runtime.GOOS == "darwin" && family == syscall.AF_INET {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, sysIP_STRIPHDR, 1); err != nil {
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err := syscall.Bind(s, sa); err != nil {
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(s), "datagram-oriented icmp")
+	defer f.Close()
+	return net.FilePacketConn(f)
+}
+
+// setsockoptInt sets an integer socket option on the underlying file descriptor
+func (c *conn) setsockoptInt(level, opt, value int) error {
+	return setsockoptInt(c.PacketConn, level, opt, value)
+}
+
+// setsockoptInt sets an integer socket option on any connection from the net package
+func setsockoptInt(c interface{}, level, opt, value int) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errors.New("socket options not supported on this connection")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+// setRecvHops asks for the TTL (IPv4) or hop limit (IPv6) of received packets to be reported
+// as control messages. A dual-stack socket can receive both, so both are attempted, and it's
+// only an error if neither could be enabled.
+func setRecvHops(c interface{}) error {
+	err4 := setsockoptInt(c, syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+	err6 := setsockoptInt(c, syscall.IPPROTO_IPV6, sysIPV6_RECVHOPLIMIT, 1)
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// parseHops returns the TTL or hop limit from the control messages enabled by setRecvHops,
+// or -1 if there isn't one
+func parseHops(oob []byte) int {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1
+	}
+	for _, m := range msgs {
+		if !(m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == sysIP_TTL_CMSG) &&
+			!(m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == sysIPV6_HOPLIMIT) {
+			continue
+		}
+
+		// Usually a native int, but Darwin reports the IPv4 TTL as a single byte
+		switch {
+		case len(m.Data) >= 4:
+			return int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		case len(m.Data) == 1:
+			return int(m.Data[0])
+		}
+	}
+	return -1
+}
+
+// SetBroadcast allows (or disallows) sending to IPv4 broadcast addresses
+func (c *conn) SetBroadcast(on bool) error {
+	if c.proto != protocolICMP {
+		return nil
+	}
+	v := 0
+	if on {
+		v = 1
+	}
+	return c.setsockoptInt(syscall.SOL_SOCKET, syscall.SO_BROADCAST, v)
+}
+
+// SetMulticastHops sets the TTL (IPv4) or hop limit (IPv6) used for multicast requests
+func (c *conn) SetMulticastHops(hops int) error {
+	if c.proto == protocolICMP {
+		return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, hops)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, hops)
+}
+
+// SetDSCP sets the DSCP value marked on outgoing packets, in the IPv4 TOS byte or the IPv6
+// traffic class (leaving the ECN bits clear)
+func (c *conn) SetDSCP(dscp int) error {
+	if c.proto == protocolICMP {
+		return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_TOS, dscp<<2)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, dscp<<2)
+}
+
+// SetUnicastHops sets the TTL (IPv4) or hop limit (IPv6) used for unicast requests
+func (c *conn) SetUnicastHops(hops int) error {
+	if c.proto == protocolICMP {
+		return c.setsockoptInt(syscall.IPPROTO_IP, syscall.IP_TTL, hops)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, hops)
+}
@@ -0,0 +1,30 @@
+package ping
+
+import (
+	"net"
+	"syscall"
+)
+
+// Socket options and control message types that differ between platforms. The IPv6
+// ones are missing from the syscall package on Darwin.
+const (
+	sysIPV6_RECVHOPLIMIT = 0x25
+	sysIPV6_HOPLIMIT     = 0x2f
+
+	// The control message type carrying the TTL of a received IPv4 packet
+	sysIP_TTL_CMSG = syscall.IP_RECVTTL
+)
+
+// Options binding a socket to an interface by index, also missing from the syscall package
+const (
+	sysIP_BOUND_IF   = 0x19
+	sysIPV6_BOUND_IF = 0x7d
+)
+
+// bindToInterface restricts the socket to sending and receiving through ifi
+func (c *conn) bindToInterface(ifi *net.Interface) error {
+	if c.proto == protocolICMP {
+		return c.setsockoptInt(syscall.IPPROTO_IP, sysIP_BOUND_IF, ifi.Index)
+	}
+	return c.setsockoptInt(syscall.IPPROTO_IPV6, sysIPV6_BOUND_IF, ifi.Index)
+}
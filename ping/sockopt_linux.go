@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Socket options and control message types that differ between platforms
+const (
+	sysIPV6_RECVHOPLIMIT = syscall.IPV6_RECVHOPLIMIT
+	sysIPV6_HOPLIMIT     = syscall.IPV6_HOPLIMIT
+
+	// The control message type carrying the TTL of a received IPv4 packet
+	sysIP_TTL_CMSG = syscall.IP_TTL
+)
+
+// bindToInterface restricts the socket to sending and receiving through ifi
+func (c *conn) bindToInterface(ifi *net.Interface) error {
+	sc, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return errors.New("socket options not supported on this connection")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, ifi.Name)
+	})
+	if err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
@@ -0,0 +1,304 @@
+package ping
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxSweepHosts bounds how many addresses a sweep may expand to, mostly to stop
+// an IPv6 prefix from being swept by accident
+const maxSweepHosts = 65536
+
+// HostStatus is the outcome of sweeping a single address
+type HostStatus struct {
+	Address   string  `json:"address"`
+	Alive     bool    `json:"alive"`
+	LatencyMs float32 `json:"latency_ms,omitempty"`
+}
+
+// ExpandSweepTarget turns the target of a sweep into the list of addresses to probe. spec may be
+// a single address, a CIDR prefix (e.g. "10.20.0.0/24", or an IPv6 prefix no larger than
+// maxSweepHosts), or "@" followed by the path of a file listing addresses or prefixes, one per line.
+func ExpandSweepTarget(spec string) ([]string, error) {
+	if strings.HasPrefix(spec, "@") {
+		return readSweepFile(spec[1:])
+	}
+	return expandPrefix(spec)
+}
+
+// readSweepFile reads addresses and prefixes from path, ignoring blank lines and '#' comments
+func readSweepFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		expanded, err := expandPrefix(line)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, expanded...)
+		if len(addrs) > maxSweepHosts {
+			return nil, fmt.Errorf("%s lists more than %d addresses", path, maxSweepHosts)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// expandPrefix returns every host address within a CIDR prefix. For IPv4 prefixes shorter
+// than /31 the network and broadcast addresses are left out. Anything without a '/' is
+// treated as a single address.
+func expandPrefix(spec string) ([]string, error) {
+	if !strings.Contains(spec, "/") {
+		if _, err := ParseTarget(spec); err != nil {
+			return nil, err
+		}
+		return []string{spec}, nil
+	}
+
+	ip, ipnet, err := net.ParseCIDR(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 62 || 1<<uint(bits-ones) > maxSweepHosts {
+		return nil, fmt.Errorf("prefix %s is too large to sweep (limit is %d addresses)", spec, maxSweepHosts)
+	}
+	size := 1 << uint(bits-ones)
+
+	base := ipnet.IP
+	if ip.To4() != nil {
+		base = base.To4()
+	}
+	first := new(big.Int).SetBytes(base)
+
+	addrs := make([]string, 0, size)
+	for i := 0; i < size; i++ {
+		if ip.To4() != nil && size > 2 && (i == 0 || i == size-1) {
+			continue
+		}
+		n := new(big.Int).Add(first, big.NewInt(int64(i)))
+		addr := make(net.IP, len(base))
+		b := n.Bytes()
+		copy(addr[len(addr)-len(b):], b)
+		addrs = append(addrs, addr.String())
+	}
+	return addrs, nil
+}
+
+// maxSweepRate is the fastest a sweep sends echo requests, per second. Tickers can't go any
+// faster than once a nanosecond anyway, and the kernel's socket buffers give out long before.
+const maxSweepRate = 1000000
+
+// Sweep probes every address concurrently, using up to workers probes in flight and starting no
+// more than rate probes per second. Each address gets up to attempts echo requests, and is
+// considered alive as soon as one of them is answered. Results are returned in the same order
+// as addrs.
+//
+// The requests all go out over one socket per address family, rather than one per request,
+// with the replies told apart by sequence number and source.
+func Sweep(addrs []string, attempts, icmpTimeout, workers, rate int) []HostStatus {
+	return sweep(context.Background(), addrs, attempts, icmpTimeout, workers, rate, nil)
+}
+
+// sweep is Sweep, but stops sending requests once ctx is cancelled. Only the statuses of the
+// addresses sent a request by then are returned. If set, emit is called with each request's
+// events, one at a time.
+func sweep(ctx context.Context, addrs []string, attempts, icmpTimeout, workers, rate int, emit func(Event)) []HostStatus {
+
+	// Hosts are swept concurrently, but events are reported one at a time
+	var mu sync.Mutex
+	report := func(e Event) {
+		if emit == nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		emit(e)
+	}
+
+	statuses := make([]HostStatus, len(addrs))
+	probed := make([]bool, len(addrs))
+
+	targets := make([]Target, len(addrs))
+	sessions := map[bool]*echoSession{}
+	for i, addr := range addrs {
+		t, err := ParseTarget(addr)
+		if err != nil {
+			log.Debugf("Error sweeping %s: %v", addr, err)
+			continue
+		}
+		targets[i] = t
+		if _, ok := sessions[t.IsIPv4()]; ok {
+			continue
+		}
+
+		// Hosts of a family that can't be pinged at all are reported dead
+		s, err := newEchoSession(t, sendOptions{})
+		if err != nil {
+			log.Errorf("Unable to open a socket for sweeping %s: %v", addr, err)
+		} else {
+			defer s.Close()
+			defer context.AfterFunc(ctx, func() {
+				s.interrupt(interruptGrace)
+			})()
+		}
+		sessions[t.IsIPv4()] = s
+	}
+
+	if rate > maxSweepRate {
+		rate = maxSweepRate
+	}
+	throttle := time.NewTicker(time.Second / time.Duration(rate))
+	defer throttle.Stop()
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s := sessions[targets[i].IsIPv4()]
+				statuses[i], probed[i] = sweepHost(ctx, s, i, addrs[i], targets[i], attempts, icmpTimeout, throttle.C, report)
+			}
+		}()
+	}
+
+feed:
+	for i := range addrs {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if ctx.Err() == nil {
+		return statuses
+	}
+	var swept []HostStatus
+	for i, status := range statuses {
+		if probed[i] {
+			swept = append(swept, status)
+		}
+	}
+	log.Warnf("Interrupted after sweeping %d of %d addresses", len(swept), len(addrs))
+	return swept
+}
+
+// sweepHost pings a single address (t, as given by addr, the index'th of the sweep) over s until
+// it answers, attempts run out or ctx is cancelled, waiting on throttle before sending each
+// request. Also returns whether any request was sent.
+func sweepHost(ctx context.Context, s *echoSession, index int, addr string, t Target, attempts, icmpTimeout int, throttle <-chan time.Time, report func(Event)) (HostStatus, bool) {
+	status := HostStatus{Address: addr}
+	if s == nil {
+		report(Event{Type: EventError, Seq: index, Time: time.Now(), Host: addr, Error: "unable to open an ICMP socket for its address family"})
+		return status, true
+	}
+	for seq := 0; seq < attempts; seq++ {
+		select {
+		case <-throttle:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			return status, seq > 0
+		}
+		sent := time.Now()
+		report(Event{Type: EventSent, Seq: index, Time: sent, Host: addr})
+		reply, err := s.pingTarget(t, s.nextSeq(), icmpTimeout)
+		if err != nil {
+			log.Debugf("Error sweeping %s: %v", addr, err)
+		}
+
+		record := newRecord(index, sent, reply.latency, reply.replied, err)
+		if reply.replied {
+			record.Responder, record.TTL, record.Size = reply.from, reply.ttl, reply.size
+		}
+		e := recordEvent(addr, record)
+		e.Host = addr
+		report(e)
+
+		if reply.replied {
+			status.Alive = true
+			status.LatencyMs = reply.latency
+			break
+		}
+	}
+	return status, true
+}
+
+// sweepRun sweeps the addresses described by target, and summarizes how many are alive (of those
+// swept before ctx was cancelled)
+func sweepRun(ctx context.Context, target string, attempts, icmpTimeout, workers, rate int, emit func(Event)) (map[string]float32, []HostStatus, error) {
+
+	addrs, err := ExpandSweepTarget(target)
+	if err != nil {
+		return nil, nil, argError(err)
+	}
+	if len(addrs) == 0 {
+		return nil, nil, argError(fmt.Errorf("'%s' contains no addresses to sweep", target))
+	}
+
+	// Hosts are only marked dead when their requests go unanswered, so make sure requests can be
+	// sent at all before starting
+	t, _ := ParseTarget(addrs[0])
+	c, err := listen(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Close()
+
+	log.Infof("Sweeping %d addresses", len(addrs))
+	hosts := sweep(ctx, addrs, attempts, icmpTimeout, workers, rate, emit)
+
+	var alive int
+	var latencyTotal float32
+	for _, host := range hosts {
+		if host.Alive {
+			alive++
+			latencyTotal += host.LatencyMs
+		}
+	}
+
+	var avgLatency float32
+	if alive > 0 {
+		avgLatency = latencyTotal / float32(alive)
+	}
+
+	metrics := map[string]float32{
+		"hosts_swept":    float32(len(hosts)),
+		"hosts_alive":    float32(alive),
+		"hosts_dead":     float32(len(hosts) - alive),
+		"avg_latency_ms": avgLatency,
+	}
+	return metrics, hosts, nil
+}
@@ -0,0 +1,127 @@
+package ping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want []string
+	}{
+		{"192.0.2.1", []string{"192.0.2.1"}},
+		{"2001:db8::1", []string{"2001:db8::1"}},
+		{"192.0.2.7/32", []string{"192.0.2.7"}},
+		{"192.0.2.0/31", []string{"192.0.2.0", "192.0.2.1"}},
+		{"192.0.2.0/30", []string{"192.0.2.1", "192.0.2.2"}},
+		{"192.0.2.5/30", []string{"192.0.2.5", "192.0.2.6"}}, // host bits are ignored
+		{"0.0.0.0/30", []string{"0.0.0.1", "0.0.0.2"}},
+		{"192.0.2.254/31", []string{"192.0.2.254", "192.0.2.255"}},
+		{"10.0.0.0/29", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}},
+		// IPv6 has no broadcast address to leave out
+		{"2001:db8::/126", []string{"2001:db8::", "2001:db8::1", "2001:db8::2", "2001:db8::3"}},
+		{"2001:db8::ff/127", []string{"2001:db8::fe", "2001:db8::ff"}},
+	} {
+		got, err := expandPrefix(tc.spec)
+		if err != nil {
+			t.Errorf("expandPrefix(%q): %v", tc.spec, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("expandPrefix(%q) = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestExpandPrefixLarge(t *testing.T) {
+	got, err := expandPrefix("10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 65534 || got[0] != "10.0.0.1" || got[255] != "10.0.1.0" || got[len(got)-1] != "10.0.255.254" {
+		t.Errorf("got %d addresses, from %s to %s", len(got), got[0], got[len(got)-1])
+	}
+
+	if got, err := expandPrefix("2001:db8::/112"); err != nil || len(got) != 65536 {
+		t.Errorf("expandPrefix(2001:db8::/112) = %d addresses, %v", len(got), err)
+	}
+}
+
+func TestExpandPrefixInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"not..an..address",
+		"192.0.2.0/33",
+		"192.0.2.0/",
+		"192.0.2/24",
+		"10.0.0.0/15",
+		"2001:db8::/64",
+		"::/0",
+	} {
+		if got, err := expandPrefix(spec); err == nil {
+			t.Errorf("expandPrefix(%q) = %d addresses, want an error", spec, len(got))
+		}
+	}
+}
+
+func TestExpandSweepTargetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	contents := "# lab\n192.0.2.0/30\n\n  2001:db8::1  # router\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ExpandSweepTarget("@" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.0.2.1", "192.0.2.2", "2001:db8::1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := os.WriteFile(path, []byte("192.0.2.1\nbogus/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExpandSweepTarget("@" + path); err == nil {
+		t.Error("no error for a file with an invalid prefix")
+	}
+	if _, err := ExpandSweepTarget("@" + path + ".missing"); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
+
+func TestSweepUnsupportedArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args map[string]interface{}
+		want string // "" if the args are accepted
+	}{
+		{"defaults", map[string]interface{}{"interval_ms": 1000, "schedule": "fixed", "schedule_seed": 0}, ""},
+		{"interval", map[string]interface{}{"interval_ms": 10}, "interval_ms"},
+		{"schedule", map[string]interface{}{"schedule": "poisson", "schedule_seed": 7}, "schedule, schedule_seed"},
+		{"jitter", map[string]interface{}{"jitter_ms": 0}, "jitter_ms"},
+		{"protocol", map[string]interface{}{"protocol": "udp", "size": 100}, "protocol, size"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := map[string]interface{}{"count": 1, "icmpTimeout": 1, "sweep": true}
+			for k, v := range tc.args {
+				args[k] = v
+			}
+
+			// An empty file sweeps nothing, so accepted args fail on that instead
+			_, err := PingTestlet{}.RunDetailed("@"+os.DevNull, args, 0)
+			if code := ErrorCode(err); code != CodeInvalidArgs {
+				t.Fatalf("ErrorCode = %s (%v), want %s", code, err, CodeInvalidArgs)
+			}
+			if tc.want == "" {
+				if strings.Contains(err.Error(), "not supported") {
+					t.Errorf("default args rejected: %v", err)
+				}
+			} else if want := "not supported in sweep mode: " + tc.want; !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q, want %q", err, want)
+			}
+		})
+	}
+}
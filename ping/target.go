@@ -0,0 +1,123 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Target is a parsed ping destination. Zone holds the IPv6 scope (an interface
+// name or index) given after a '%' in targets such as "fe80::1%eth0", and is
+// required to reach link-local neighbors.
+type Target struct {
+	IP   net.IP
+	Zone string
+}
+
+// ParseTarget parses a literal IPv4 or IPv6 address, with an optional "%zone"
+// suffix for IPv6 link-local and multicast addresses
+func ParseTarget(s string) (Target, error) {
+	addr, zone := s, ""
+	if i := strings.LastIndex(s, "%"); i >= 0 {
+		addr, zone = s[:i], s[i+1:]
+		if zone == "" {
+			return Target{}, argError(fmt.Errorf("empty zone in target '%s'", s))
+		}
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return Target{}, argError(fmt.Errorf("'%s' is not a valid IP address", addr))
+	}
+
+	t := Target{IP: ip, Zone: zone}
+
+	if zone != "" {
+		if t.IsIPv4() {
+			return Target{}, argError(errors.New("zones are only supported for IPv6 targets"))
+		}
+		if _, err := zoneInterface(zone); err != nil {
+			return Target{}, argError(err)
+		}
+	} else if !t.IsIPv4() && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()) {
+		return Target{}, argError(fmt.Errorf("link-local target '%s' requires a zone (e.g. %s%%eth0)", s, s))
+	}
+
+	return t, nil
+}
+
+// resolveTarget returns the address to ping for target: a literal address as it is (which has to
+// be of the given family), or otherwise the first address of that family the hostname resolves to
+func resolveTarget(target, family string) (string, error) {
+	addr := target
+	if i := strings.LastIndex(addr, "%"); i >= 0 {
+		addr = addr[:i]
+	}
+	if net.ParseIP(addr) != nil {
+		t, err := ParseTarget(target)
+		if err != nil {
+			return "", err
+		}
+		if family == familyIPv4 && !t.IsIPv4() || family == familyIPv6 && t.IsIPv4() {
+			return "", argError(fmt.Errorf("%s is not an %s address", target, family))
+		}
+		return target, nil
+	}
+
+	ips, err := net.LookupIP(target)
+	if err != nil {
+		return "", argError(fmt.Errorf("'%s' is neither an IP address nor a resolvable hostname", target))
+	}
+	for _, ip := range ips {
+		if family == familyAny || (ip.To4() != nil) == (family == familyIPv4) {
+			return ip.String(), nil
+		}
+	}
+	return "", argError(fmt.Errorf("'%s' has no %s address", target, family))
+}
+
+// IsIPv4 returns true if the target is an IPv4 (or IPv4-mapped) address
+func (t Target) IsIPv4() bool {
+	return t.IP.To4() != nil
+}
+
+// IsMulticast returns true if more than one host may answer a probe sent to this target
+func (t Target) IsMulticast() bool {
+	return t.IP.IsMulticast()
+}
+
+// String returns the target in the same "addr%zone" form accepted by ParseTarget
+func (t Target) String() string {
+	if t.Zone == "" {
+		return t.IP.String()
+	}
+	return t.IP.String() + "%" + t.Zone
+}
+
+// addr returns the destination address to use with a socket opened on the
+// given network ("ip4:icmp", "udp6", etc.), with the zone carried over
+func (t Target) addr(network string) net.Addr {
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: t.IP, Zone: t.Zone}
+	}
+	return &net.IPAddr{IP: t.IP, Zone: t.Zone}
+}
+
+// zoneInterface looks up the interface referred to by an IPv6 zone, which may
+// be either an interface name or a numeric index
+func zoneInterface(zone string) (*net.Interface, error) {
+	if index, err := strconv.Atoi(zone); err == nil {
+		ifi, err := net.InterfaceByIndex(index)
+		if err != nil {
+			return nil, fmt.Errorf("no interface with index %d for zone '%s'", index, zone)
+		}
+		return ifi, nil
+	}
+	ifi, err := net.InterfaceByName(zone)
+	if err != nil {
+		return nil, fmt.Errorf("no interface named '%s' for zone", zone)
+	}
+	return ifi, nil
+}
@@ -0,0 +1,110 @@
+package ping
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+// loopback returns the loopback interface, which any zone tests can be scoped to
+func loopback(t *testing.T) net.Interface {
+	t.Helper()
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagLoopback != 0 {
+			return ifi
+		}
+	}
+	t.Skip("no loopback interface")
+	return net.Interface{}
+}
+
+func TestParseTarget(t *testing.T) {
+	lo := loopback(t)
+	index := strconv.Itoa(lo.Index)
+
+	for _, tc := range []struct {
+		target   string
+		ip, zone string // "" for an error
+		ipv4     bool
+	}{
+		{"192.0.2.1", "192.0.2.1", "", true},
+		{"::ffff:192.0.2.1", "192.0.2.1", "", true},
+		{"2001:db8::1", "2001:db8::1", "", false},
+		{"ff02::1%" + lo.Name, "ff02::1", lo.Name, false},
+		{"fe80::1%" + lo.Name, "fe80::1", lo.Name, false},
+		{"fe80::1%" + index, "fe80::1", index, false},
+		{"2001:db8::1%" + lo.Name, "2001:db8::1", lo.Name, false},
+
+		// Link-local targets need a zone to say which link they're on
+		{"fe80::1", "", "", false},
+		{"ff02::1", "", "", false},
+		{"ff01::1", "", "", false},
+		{"fe80::1%", "", "", false},
+		{"fe80::1%nosuch0", "", "", false},
+		{"fe80::1%999999", "", "", false},
+		{"192.0.2.1%" + lo.Name, "", "", false},
+		{"example.com", "", "", false},
+		{"", "", "", false},
+	} {
+		got, err := ParseTarget(tc.target)
+		if tc.ip == "" {
+			if err == nil {
+				t.Errorf("ParseTarget(%q) = %v, want an error", tc.target, got)
+			} else if code := ErrorCode(err); code != CodeInvalidArgs {
+				t.Errorf("ParseTarget(%q): ErrorCode = %s, want %s", tc.target, code, CodeInvalidArgs)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTarget(%q): %v", tc.target, err)
+			continue
+		}
+		if !got.IP.Equal(net.ParseIP(tc.ip)) || got.Zone != tc.zone || got.IsIPv4() != tc.ipv4 {
+			t.Errorf("ParseTarget(%q) = %v (IPv4 %t), want %s%%%s (IPv4 %t)", tc.target, got, got.IsIPv4(), tc.ip, tc.zone, tc.ipv4)
+		}
+		if tc.zone != "" && got.String() != tc.target {
+			t.Errorf("%q round trips to %q", tc.target, got.String())
+		}
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	lo := loopback(t)
+
+	for _, tc := range []struct {
+		target, family string
+		want           string // "" for an error
+	}{
+		{"192.0.2.1", familyAny, "192.0.2.1"},
+		{"192.0.2.1", familyIPv4, "192.0.2.1"},
+		{"192.0.2.1", familyIPv6, ""},
+		{"2001:db8::1", familyAny, "2001:db8::1"},
+		{"2001:db8::1", familyIPv6, "2001:db8::1"},
+		{"2001:db8::1", familyIPv4, ""},
+		{"fe80::1%" + lo.Name, familyIPv6, "fe80::1%" + lo.Name},
+		{"fe80::1%" + lo.Name, familyIPv4, ""},
+		{"fe80::1", familyAny, ""},
+
+		// Hostnames resolve to an address of the family asked for
+		{"localhost", familyIPv4, "127.0.0.1"},
+		{"nosuchhost.invalid", familyAny, ""},
+	} {
+		got, err := resolveTarget(tc.target, tc.family)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("resolveTarget(%q, %s) = %q, want an error", tc.target, tc.family, got)
+			} else if code := ErrorCode(err); code != CodeInvalidArgs {
+				t.Errorf("resolveTarget(%q, %s): ErrorCode = %s, want %s", tc.target, tc.family, code, CodeInvalidArgs)
+			}
+		} else if err != nil {
+			t.Errorf("resolveTarget(%q, %s): %v", tc.target, tc.family, err)
+		} else if got != tc.want {
+			t.Errorf("resolveTarget(%q, %s) = %q, want %q", tc.target, tc.family, got, tc.want)
+		}
+	}
+}
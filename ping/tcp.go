@@ -0,0 +1,73 @@
+package ping
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// PingTCP measures reachability of target by opening a TCP connection to port, for targets that
+// filter ICMP. The handshake completes when the target answers our SYN with a SYN-ACK; a closed port
+// answers with a RST instead, which is just as good a sign of life and is timed the same way.
+// returns:
+// float32 - time until the SYN-ACK or RST arrived, in milliseconds
+// bool - true if either arrived before timeout
+// error - nil if everything went well
+func PingTCP(target string, port, timeout int) (float32, bool, error) {
+	return pingTCP(context.Background(), target, port, timeout)
+}
+
+// pingTCP is PingTCP, giving up on the handshake once ctx has been cancelled for interruptGrace
+func pingTCP(ctx context.Context, target string, port, timeout int) (float32, bool, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return 0.0, false, err
+	}
+
+	addr := net.JoinHostPort(t.String(), strconv.Itoa(port))
+
+	gctx, cancel := graceContext(ctx)
+	defer cancel()
+	d := net.Dialer{Timeout: time.Duration(timeout) * time.Second}
+
+	start := time.Now()
+	c, err := d.DialContext(gctx, "tcp", addr)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		if isErrno(err, syscall.ECONNREFUSED) {
+			log.Debugf("Connection to %s refused", addr)
+			return float32(elapsed.Seconds() * 1e3), true, nil
+		}
+
+		// Timeouts and unreachable destinations both count as loss
+		log.Debugf("TCP probe to %s failed: %v", addr, err)
+		return 0.0, false, nil
+	}
+
+	// Reset the connection rather than closing it gracefully, so that probing doesn't leave
+	// sockets lingering in TIME_WAIT on either side
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(0)
+	}
+	c.Close()
+
+	return float32(elapsed.Seconds() * 1e3), true, nil
+}
+
+// isErrno checks whether err was ultimately caused by the given system error
+func isErrno(err error, errno syscall.Errno) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if sysErr, ok := err.(*os.SyscallError); ok {
+		err = sysErr.Err
+	}
+	return err == errno
+}
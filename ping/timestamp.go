@@ -0,0 +1,178 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ICMP timestamp message types (RFC 792). These aren't parsed by the vendored icmp package.
+const (
+	icmpTypeTimestamp      = ipv4.ICMPType(13)
+	icmpTypeTimestampReply = ipv4.ICMPType(14)
+)
+
+// Timestamps are milliseconds since midnight UT, which wrap once a day
+const msPerDay = 24 * 60 * 60 * 1000
+
+// TimestampSample is what can be learned from a single exchange of timestamps with the target
+// (an ICMP timestamp request, or a TWAMP test packet).
+//
+// Forward and reverse delays are measured across two clocks, so each includes the remote
+// clock's offset (with opposite signs). They're only true one-way delays when the clocks agree,
+// but their difference still exposes path asymmetry. ICMP timestamps only have millisecond
+// resolution, since that's all the protocol carries.
+type TimestampSample struct {
+	RTTMs     float32
+	ForwardMs float32 // remote receive - originate
+	ReverseMs float32 // our receive - remote transmit
+}
+
+// OffsetMs estimates how far ahead the remote clock is, assuming symmetric paths
+func (s TimestampSample) OffsetMs() float32 {
+	return (s.ForwardMs - s.ReverseMs) / 2
+}
+
+// PingTimestamp sends an ICMP timestamp request to an IPv4 target and waits for the reply.
+// Requires a raw socket, since datagram ICMP sockets only carry echo requests.
+// returns:
+// *TimestampSample - the measured delays, or nil if no reply was received before timeout
+// error - nil if everything went well
+func PingTimestamp(target string, seq, icmpTimeout int) (*TimestampSample, error) {
+	return pingTimestamp(context.Background(), target, seq, icmpTimeout)
+}
+
+// pingTimestamp is PingTimestamp, giving up on the reply once ctx has been cancelled for
+// interruptGrace
+func pingTimestamp(ctx context.Context, target string, seq, icmpTimeout int) (*TimestampSample, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if !t.IsIPv4() {
+		return nil, errors.New("ICMP timestamp requests are only defined for IPv4")
+	}
+
+	c, err := listen(t)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if !c.raw() {
+		return nil, rawSocketRequired("sending ICMP timestamp requests")
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Duration(icmpTimeout) * time.Second))
+	defer cutShort(ctx, c)()
+
+	// Identifier, sequence number, then the originate, receive and transmit timestamps
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint16(data[0:2], uint16(os.Getpid()&0xffff))
+	binary.BigEndian.PutUint16(data[2:4], uint16(seq))
+
+	start := time.Now()
+	originate := msSinceMidnight(start)
+	binary.BigEndian.PutUint32(data[4:8], originate)
+
+	wm := icmp.Message{Type: icmpTypeTimestamp, Code: 0, Body: &icmp.DefaultMessageBody{Data: data}}
+	wb, err := wm.Marshal(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.WriteTo(wb, t.addr(c.network)); err != nil {
+		return nil, err
+	}
+
+	rb := make([]byte, 1500)
+	for {
+		n, peer, err := c.ReadFrom(rb)
+		if err != nil {
+			log.Debugf("Timestamp request timeout on %v", t)
+			return nil, nil
+		}
+		now := time.Now()
+
+		if !peerIP(peer).Equal(t.IP) {
+			continue
+		}
+		rm, err := icmp.ParseMessage(c.proto, rb[:n])
+		if err != nil || rm.Type != icmpTypeTimestampReply {
+			continue
+		}
+		body, ok := rm.Body.(*icmp.DefaultMessageBody)
+		if !ok || len(body.Data) < 16 {
+			continue
+		}
+		b := body.Data
+		if binary.BigEndian.Uint16(b[0:2]) != uint16(os.Getpid()&0xffff) || binary.BigEndian.Uint16(b[2:4]) != uint16(seq) {
+			continue
+		}
+
+		receive := binary.BigEndian.Uint32(b[8:12])
+		transmit := binary.BigEndian.Uint32(b[12:16])
+
+		// The high bit flags a timestamp that isn't milliseconds since midnight UT (RFC 792),
+		// which can't be compared with ours
+		if receive&0x80000000 != 0 || transmit&0x80000000 != 0 {
+			return nil, errors.New("target replied with non-standard timestamps")
+		}
+
+		return &TimestampSample{
+			RTTMs:     float32(now.Sub(start).Seconds() * 1e3),
+			ForwardMs: float32(msDiff(receive, originate)),
+			ReverseMs: float32(msDiff(msSinceMidnight(now), transmit)),
+		}, nil
+	}
+}
+
+// msSinceMidnight returns t as an RFC 792 timestamp
+func msSinceMidnight(t time.Time) uint32 {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return uint32(t.Sub(midnight) / time.Millisecond)
+}
+
+// msDiff returns a - b, allowing for either side having wrapped past midnight
+func msDiff(a, b uint32) int64 {
+	d := (int64(a) - int64(b)) % msPerDay
+	if d > msPerDay/2 {
+		d -= msPerDay
+	} else if d < -msPerDay/2 {
+		d += msPerDay
+	}
+	return d
+}
+
+// oneWayMetrics summarizes a run's timestamp exchanges (from PingTimestamp or PingTWAMP). Delays
+// are averaged, while the clock offset comes from the exchange with the lowest round trip time,
+// as that one had the least queueing to skew it.
+func oneWayMetrics(samples []TimestampSample) map[string]float32 {
+
+	metrics := map[string]float32{}
+	if len(samples) == 0 {
+		return metrics
+	}
+
+	var forwardTotal, reverseTotal float32
+	best := samples[0]
+	for _, s := range samples {
+		forwardTotal += s.ForwardMs
+		reverseTotal += s.ReverseMs
+		if s.RTTMs < best.RTTMs {
+			best = s
+		}
+	}
+
+	metrics["owd_forward_ms"] = forwardTotal / float32(len(samples))
+	metrics["owd_reverse_ms"] = reverseTotal / float32(len(samples))
+	metrics["clock_offset_ms"] = best.OffsetMs()
+	return metrics
+}
@@ -0,0 +1,44 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMsDiff(t *testing.T) {
+	for _, tc := range []struct {
+		a, b uint32
+		want int64
+	}{
+		{0, 0, 0},
+		{1000, 500, 500},
+		{500, 1000, -500},
+		// One side has passed midnight and the other hasn't
+		{100, msPerDay - 100, 200},
+		{msPerDay - 100, 100, -200},
+		{msPerDay / 2, 0, msPerDay / 2},
+		{0, msPerDay / 2, -msPerDay / 2},
+		{msPerDay/2 + 1, 0, -(msPerDay/2 - 1)},
+	} {
+		if got := msDiff(tc.a, tc.b); got != tc.want {
+			t.Errorf("msDiff(%d, %d) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestMsSinceMidnight(t *testing.T) {
+	for _, tc := range []struct {
+		t    time.Time
+		want uint32
+	}{
+		{time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC), 0},
+		{time.Date(2017, 3, 1, 0, 0, 1, 999999, time.UTC), 1000},
+		{time.Date(2017, 3, 1, 23, 59, 59, 999000000, time.UTC), msPerDay - 1},
+		// Timestamps are always relative to midnight UTC
+		{time.Date(2017, 3, 1, 1, 30, 0, 0, time.FixedZone("CET", 3600)), 30 * 60 * 1000},
+	} {
+		if got := msSinceMidnight(tc.t); got != tc.want {
+			t.Errorf("msSinceMidnight(%v) = %d, want %d", tc.t, got, tc.want)
+		}
+	}
+}
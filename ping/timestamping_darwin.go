@@ -0,0 +1,38 @@
+package ping
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// enableTimestamps asks the kernel to timestamp received packets on c. Darwin has no
+// equivalent of SO_TIMESTAMPING, so send times always come from userspace.
+func (c *conn) enableTimestamps() {
+	c.setsockoptInt(syscall.SOL_SOCKET, syscall.SO_TIMESTAMP, 1)
+}
+
+// rxTimestamp returns the kernel's timestamp from the control messages of a received packet
+func rxTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	for _, m := range msgs {
+		if m.Header.Level != syscall.SOL_SOCKET || m.Header.Type != syscall.SCM_TIMESTAMP {
+			continue
+		}
+		if len(m.Data) < int(unsafe.Sizeof(syscall.Timeval{})) {
+			continue
+		}
+		tv := (*syscall.Timeval)(unsafe.Pointer(&m.Data[0]))
+		return time.Unix(tv.Unix()), true
+	}
+	return time.Time{}, false
+}
+
+// txTimestamp is unsupported on Darwin
+func (c *conn) txTimestamp() (time.Time, bool) {
+	return time.Time{}, false
+}
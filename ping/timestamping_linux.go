@@ -0,0 +1,88 @@
+package ping
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// SO_TIMESTAMPING flags (linux/net_tstamp.h)
+const (
+	sysSOF_TIMESTAMPING_TX_SOFTWARE = 1 << 1
+	sysSOF_TIMESTAMPING_RX_SOFTWARE = 1 << 3
+	sysSOF_TIMESTAMPING_SOFTWARE    = 1 << 4
+	sysSOF_TIMESTAMPING_OPT_TSONLY  = 1 << 11
+)
+
+// enableTimestamps asks the kernel to timestamp packets on c. SO_TIMESTAMPING gives both send
+// and receive timestamps; older kernels fall back to SO_TIMESTAMPNS, which only gives receive
+// timestamps. If neither is available, callers time packets themselves.
+func (c *conn) enableTimestamps() {
+	flags := sysSOF_TIMESTAMPING_TX_SOFTWARE | sysSOF_TIMESTAMPING_RX_SOFTWARE |
+		sysSOF_TIMESTAMPING_SOFTWARE | sysSOF_TIMESTAMPING_OPT_TSONLY
+	if err := c.setsockoptInt(syscall.SOL_SOCKET, syscall.SO_TIMESTAMPING, flags); err == nil {
+		c.txTimestamps = true
+		return
+	}
+	c.setsockoptInt(syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+}
+
+// rxTimestamp returns the kernel's timestamp from the control messages of a received packet
+func rxTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	tsLen := int(unsafe.Sizeof(syscall.Timespec{}))
+	for _, m := range msgs {
+		if m.Header.Level != syscall.SOL_SOCKET || len(m.Data) < tsLen {
+			continue
+		}
+
+		// SO_TIMESTAMPING reports three timestamps, of which the first is the software one
+		switch m.Header.Type {
+		case syscall.SCM_TIMESTAMPNS, syscall.SCM_TIMESTAMPING:
+			ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+			if ts.Sec == 0 && ts.Nsec == 0 {
+				continue
+			}
+			return time.Unix(ts.Unix()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// txTimestamp returns the kernel's send timestamp for the last packet written to c, which is
+// queued on the socket's error queue (along with any older ones that weren't collected). It
+// doesn't wait; if there's nothing there, ok is false.
+func (c *conn) txTimestamp() (time.Time, bool) {
+	if !c.txTimestamps {
+		return time.Time{}, false
+	}
+
+	sc, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return time.Time{}, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var last time.Time
+	rc.Control(func(fd uintptr) {
+		b := make([]byte, 1500)
+		oob := make([]byte, 256)
+		for {
+			_, oobn, _, _, err := syscall.Recvmsg(int(fd), b, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			if ts, ok := rxTimestamp(oob[:oobn]); ok {
+				last = ts
+			}
+		}
+	})
+	return last, !last.IsZero()
+}
@@ -0,0 +1,157 @@
+package ping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TWAMP-Light test packets, in the unauthenticated format (RFC 5357, sections 4.1.2 and 4.2.1)
+const (
+	twampPort = 862
+
+	// Sequence number, timestamp and error estimate
+	twampSenderLen = 14
+
+	// The reflector adds its own receive timestamp, and echoes the sender's fields and TTL
+	twampReflectorLen = 41
+
+	// Error estimate: not synchronized to UTC (S=0), NTP format (Z=0), and a scale and
+	// multiplier giving an estimate of 2^-10 seconds (about 1ms)
+	twampErrorEstimate = 22<<8 | 1
+)
+
+// Seconds between the NTP epoch (1900) and the Unix epoch (1970)
+const ntpEpochOffset = 2208988800
+
+// PingTWAMP sends a TWAMP-Light test packet to a reflector at port on target. The reflector's
+// receive and transmit timestamps let the RTT exclude the reflector's own processing time, and
+// split it into forward and reverse delays (which, like those from PingTimestamp, include
+// any offset between the two clocks).
+// returns:
+// *TimestampSample - the measured delays, or nil if no reply was received before timeout
+// error - nil if everything went well
+func PingTWAMP(target string, seq, port, timeout int) (*TimestampSample, error) {
+	return pingTWAMP(context.Background(), target, seq, port, timeout)
+}
+
+// pingTWAMP is PingTWAMP, giving up on the reply once ctx has been cancelled for interruptGrace
+func pingTWAMP(ctx context.Context, target string, seq, port, timeout int) (*TimestampSample, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := net.Dial("udp", net.JoinHostPort(t.String(), strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	// Test packets are sent with the maximum TTL, so the reflector's copy of the received TTL
+	// tells how many hops the forward path took
+	if t.IsIPv4() {
+		err = setsockoptInt(c, syscall.IPPROTO_IP, syscall.IP_TTL, 255)
+	} else {
+		err = setsockoptInt(c, syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, 255)
+	}
+	if err != nil {
+		log.Debugf("Unable to set TTL for TWAMP test packets: %v", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	defer cutShort(ctx, c)()
+
+	// Padded to the length of the reflected packet, so that both directions carry the same size
+	wb := make([]byte, twampReflectorLen)
+	binary.BigEndian.PutUint32(wb[0:4], uint32(seq))
+	binary.BigEndian.PutUint16(wb[12:14], twampErrorEstimate)
+
+	sent := time.Now()
+	binary.BigEndian.PutUint64(wb[4:12], ntpTimestamp(sent))
+	if _, err := c.Write(wb); err != nil {
+		return nil, err
+	}
+
+	rb := make([]byte, 1500)
+	for {
+		n, err := c.Read(rb)
+		if err != nil {
+			log.Debugf("TWAMP test packet to %s:%d failed: %v", t, port, err)
+			return nil, nil
+		}
+		now := time.Now()
+
+		if n < twampReflectorLen {
+			continue
+		}
+		b := rb[:n]
+
+		// The reflector echoes our sequence number and timestamp back
+		if binary.BigEndian.Uint32(b[24:28]) != uint32(seq) || binary.BigEndian.Uint64(b[28:36]) != binary.BigEndian.Uint64(wb[4:12]) {
+			continue
+		}
+
+		received := fromNTP(binary.BigEndian.Uint64(b[16:24]))
+		transmitted := fromNTP(binary.BigEndian.Uint64(b[4:12]))
+
+		return &TimestampSample{
+			RTTMs:     durationMs(now.Sub(sent) - transmitted.Sub(received)),
+			ForwardMs: durationMs(received.Sub(sent)),
+			ReverseMs: durationMs(now.Sub(transmitted)),
+		}, nil
+	}
+}
+
+// twampReflect turns a received test packet into the reflector's reply, in stateless mode (which
+// copies the sender's sequence number rather than keeping a count per sender)
+func twampReflect(in []byte, received time.Time, ttl int, transmit time.Time) ([]byte, error) {
+	if len(in) < twampSenderLen {
+		return nil, errors.New("TWAMP test packet too short")
+	}
+
+	l := twampReflectorLen
+	if len(in) > l {
+		l = len(in)
+	}
+	out := make([]byte, l)
+
+	copy(out[0:4], in[0:4])
+	binary.BigEndian.PutUint64(out[4:12], ntpTimestamp(transmit))
+	binary.BigEndian.PutUint16(out[12:14], twampErrorEstimate)
+	binary.BigEndian.PutUint64(out[16:24], ntpTimestamp(received))
+
+	// Sender's sequence number, timestamp and error estimate
+	copy(out[24:38], in[0:14])
+
+	if ttl >= 0 {
+		out[40] = byte(ttl)
+	}
+	return out, nil
+}
+
+// ntpTimestamp encodes t as a 64-bit NTP timestamp: seconds since 1900, and a 32-bit fraction
+func ntpTimestamp(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+// fromNTP decodes a 64-bit NTP timestamp
+func fromNTP(v uint64) time.Time {
+	secs := int64(v>>32) - ntpEpochOffset
+	nsec := int64((v & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nsec)
+}
+
+// durationMs expresses d in milliseconds, as used for all latency metrics
+func durationMs(d time.Duration) float32 {
+	return float32(d.Seconds() * 1e3)
+}
@@ -0,0 +1,40 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNTPTimestamp(t *testing.T) {
+	for _, tc := range []struct {
+		t    time.Time
+		want uint64
+	}{
+		{time.Unix(0, 0), ntpEpochOffset << 32},
+		{time.Unix(0, 500000000), ntpEpochOffset<<32 | 0x80000000},
+		{time.Unix(1, 250000000), (ntpEpochOffset+1)<<32 | 0x40000000},
+		{time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC), 0},
+	} {
+		if got := ntpTimestamp(tc.t); got != tc.want {
+			t.Errorf("ntpTimestamp(%v) = %#x, want %#x", tc.t, got, tc.want)
+		}
+		if got := fromNTP(tc.want); !got.Equal(tc.t) {
+			t.Errorf("fromNTP(%#x) = %v, want %v", tc.want, got, tc.t)
+		}
+	}
+}
+
+func TestNTPRoundTrip(t *testing.T) {
+	for _, ts := range []time.Time{
+		time.Unix(1500000000, 1),
+		time.Unix(1500000000, 123456789),
+		time.Unix(1500000000, 999999999),
+		time.Date(2036, 2, 7, 6, 28, 15, 777, time.UTC), // the last second of NTP era 0
+	} {
+		// The 32-bit fraction has a resolution of about 0.23 ns, so decoding can round down by 1 ns
+		got := fromNTP(ntpTimestamp(ts))
+		if d := ts.Sub(got); d < 0 || d > time.Nanosecond {
+			t.Errorf("%v came back as %v", ts, got)
+		}
+	}
+}
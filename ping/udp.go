@@ -0,0 +1,78 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// udpPayload is what UDP probes carry, followed by the sequence number
+var udpPayload = []byte("hanshotfirst")
+
+// PingUDP sends a datagram to port on target, and treats either of two things as a reply: the
+// datagram being echoed back (by the echo service on port 7, or any other reflector), or an ICMP
+// port unreachable saying nothing is listening. Either way the target is alive, and the RTT is
+// measured to whichever arrives first.
+// returns:
+// float32 - response time in milliseconds
+// bool - true if reply recieved before timeout
+// error - nil if everything went well
+func PingUDP(target string, seq, port, timeout int) (float32, bool, error) {
+	return pingUDP(context.Background(), target, seq, port, timeout)
+}
+
+// pingUDP is PingUDP, giving up on the reply once ctx has been cancelled for interruptGrace
+func pingUDP(ctx context.Context, target string, seq, port, timeout int) (float32, bool, error) {
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return 0.0, false, err
+	}
+
+	// A connected socket has ICMP errors about our datagrams reported back on it
+	c, err := net.Dial("udp", net.JoinHostPort(t.String(), strconv.Itoa(port)))
+	if err != nil {
+		return 0.0, false, err
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Second))
+	defer cutShort(ctx, c)()
+
+	wb := make([]byte, len(udpPayload)+4)
+	copy(wb, udpPayload)
+	binary.BigEndian.PutUint32(wb[len(udpPayload):], uint32(seq))
+
+	start := time.Now()
+	if _, err := c.Write(wb); err != nil {
+		return 0.0, false, err
+	}
+
+	rb := make([]byte, 1500)
+	for {
+		n, err := c.Read(rb)
+		elapsed := time.Since(start)
+
+		if err != nil {
+			if isErrno(err, syscall.ECONNREFUSED) {
+				log.Debugf("Port unreachable from %s:%d", t, port)
+				return float32(elapsed.Seconds() * 1e3), true, nil
+			}
+			// Timeouts and unreachable destinations both count as loss
+			log.Debugf("UDP probe to %s:%d failed: %v", t, port, err)
+			return 0.0, false, nil
+		}
+
+		// Anything other than our own datagram (such as a late echo of an earlier
+		// probe) is ignored
+		if bytes.Equal(rb[:n], wb) {
+			return float32(elapsed.Seconds() * 1e3), true, nil
+		}
+	}
+}